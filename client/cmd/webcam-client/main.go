@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"log"
 
 	"webcam-transfer/client/internal/application"
@@ -20,9 +21,27 @@ func main() {
 	// Инициализируем логгер
 	stdLogger := logger.NewStdLogger(config.Debug)
 
+	// Настраиваем TLS для подключения по wss://
+	var tlsConfig *tls.Config
+	if config.TLS {
+		var err error
+		tlsConfig, err = streaming.NewTLSConfig(streaming.TLSOptions{
+			CAFile:             config.CAFile,
+			InsecureSkipVerify: config.Insecure,
+			CertFile:           config.CertFile,
+			KeyFile:            config.KeyFile,
+		})
+		if err != nil {
+			log.Fatalf("Ошибка настройки TLS: %v", err)
+		}
+		if config.Insecure {
+			stdLogger.Info("Внимание: проверка сертификата сервера отключена")
+		}
+	}
+
 	// Инициализируем инфраструктурные компоненты
 	cameraManager := camera.NewMediaDevicesManager(stdLogger)
-	streamManager := streaming.NewWebSocketStreamer(stdLogger, config.Debug)
+	streamManager := streaming.NewWebSocketStreamer(stdLogger, config.Debug, tlsConfig)
 
 	// Инициализируем сервис приложения
 	webcamService := application.NewWebcamService(cameraManager, streamManager, stdLogger)
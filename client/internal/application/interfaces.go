@@ -17,11 +17,16 @@ type CameraManager interface {
 
 // StreamManager интерфейс для управления стримингом
 type StreamManager interface {
-	// StartStreaming начинает стриминг видео
+	// StartStreaming подключается к серверу и передает видео, пока не будет отменен ctx
+	// или не произойдет ошибка. Обрыв уже установленного соединения возвращается
+	// как domain.ErrConnectionLost.
 	StartStreaming(ctx context.Context, track domain.VideoTrack, config domain.VideoConfig) error
 
 	// StopStreaming останавливает стриминг
 	StopStreaming() error
+
+	// IsConnected возвращает статус подключения к серверу
+	IsConnected() bool
 }
 
 // Logger интерфейс для логирования
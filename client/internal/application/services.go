@@ -4,11 +4,55 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"sync"
+	"time"
 
 	"webcam-transfer/client/internal/domain"
 )
 
+// ReconnectPolicy задает, как часто переподключаться к серверу после ошибки.
+// Интервал растет в Multiplier раз с каждой неудачной попыткой, но не больше MaxInterval,
+// и случайно отклоняется на долю Jitter, чтобы камеры не переподключались одновременно.
+type ReconnectPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64 // от 0 до 1
+	MaxAttempts     int     // попыток подряд до отказа (0 — без ограничения)
+}
+
+// DefaultReconnectPolicy возвращает политику переподключения по умолчанию
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     30 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+}
+
+// Delay возвращает интервал перед попыткой переподключения attempt (начиная с 1)
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(p.MaxInterval))
+	delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(math.Min(delay, float64(p.MaxInterval)))
+}
+
+// ErrReconnectAttemptsExhausted — сервис перестал переподключаться после MaxAttempts неудач
+var ErrReconnectAttemptsExhausted = errors.New("исчерпаны попытки переподключения к серверу")
+
+// CaptureStatus — состояние захвата и передачи видео
+type CaptureStatus struct {
+	Capturing   bool      // камера открыта
+	Connected   bool      // видео передается на сервер
+	Attempt     int       // номер текущей попытки переподключения (0 — не переподключаемся)
+	NextAttempt time.Time // время следующей попытки
+	LastError   string    // последняя ошибка стриминга
+}
+
 // WebcamService сервис для работы с веб-камерой и стримингом
 type WebcamService struct {
 	cameraManager CameraManager
@@ -19,6 +63,10 @@ type WebcamService struct {
 	streamContext context.Context
 	cancelFunc    context.CancelFunc
 	mutex         sync.Mutex
+
+	reconnect ReconnectPolicy
+	status    CaptureStatus
+	done      chan error // получает ошибку, после которой стриминг прекращен
 }
 
 // NewWebcamService создает новый сервис для работы с веб-камерой
@@ -27,9 +75,38 @@ func NewWebcamService(cameraManager CameraManager, streamManager StreamManager,
 		cameraManager: cameraManager,
 		streamManager: streamManager,
 		logger:        logger,
+		reconnect:     DefaultReconnectPolicy(),
+		done:          make(chan error, 1),
 	}
 }
 
+// SetReconnectPolicy задает политику переподключения для следующего StartCapture
+func (s *WebcamService) SetReconnectPolicy(policy ReconnectPolicy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reconnect = policy
+}
+
+// Status возвращает текущее состояние захвата и передачи видео
+func (s *WebcamService) Status() CaptureStatus {
+	s.mutex.Lock()
+	status := s.status
+	s.mutex.Unlock()
+
+	status.Connected = status.Capturing && s.streamManager.IsConnected()
+	if status.Connected {
+		status.Attempt = 0
+		status.NextAttempt = time.Time{}
+	}
+	return status
+}
+
+// Done возвращает канал, в который приходит ошибка, если стриминг прекращен
+// без вызова StopCapture (например, исчерпаны попытки переподключения)
+func (s *WebcamService) Done() <-chan error {
+	return s.done
+}
+
 // ListDevices возвращает список доступных устройств захвата
 func (s *WebcamService) ListDevices() ([]domain.VideoDevice, error) {
 	devices, err := s.cameraManager.ListDevices()
@@ -63,18 +140,86 @@ func (s *WebcamService) StartCapture(config domain.VideoConfig) error {
 	s.activeTrack = track
 	s.logger.Info("Используется камера: %s", track.ID())
 
-	// Начинаем стриминг
+	// Начинаем стриминг. Камера остается открытой, пока сервис переподключается к серверу
 	s.streamContext, s.cancelFunc = context.WithCancel(context.Background())
+	s.status = CaptureStatus{Capturing: true}
+	go s.stream(s.streamContext, track, config, s.reconnect)
+
+	return nil
+}
 
-	go func() {
-		fmt.Println(s.streamContext, track, config)
-		err := s.streamManager.StartStreaming(s.streamContext, track, config)
-		if err != nil {
-			s.logger.Error("Ошибка стриминга: %v", err)
+// stream передает видео на сервер и переподключается после ошибок по политике policy,
+// пока не будет отменен ctx или не будут исчерпаны попытки
+func (s *WebcamService) stream(ctx context.Context, track domain.VideoTrack, config domain.VideoConfig, policy ReconnectPolicy) {
+	attempt := 0
+	for {
+		err := s.streamManager.StartStreaming(ctx, track, config)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("стриминг завершился без ошибки")
+		}
+		s.logger.Error("Ошибка стриминга: %v", err)
+
+		// Перехваченный поток опубликован другим клиентом: переподключение отняло бы его обратно,
+		// и два издателя отключали бы друг друга бесконечно
+		if errors.Is(err, domain.ErrStreamTakenOver) {
+			s.logger.Error("Поток перехвачен другим издателем, стриминг прекращен")
+			s.stop(ctx, err)
+			return
 		}
-	}()
 
-	return nil
+		// Обрыв установленного соединения начинает отсчет попыток заново. Отключение сервером
+		// (ограничения, некорректный поток) считается неудачной попыткой: иначе клиент,
+		// которого сервер каждый раз отключает, переподключался бы без конца.
+		if errors.Is(err, domain.ErrConnectionLost) {
+			attempt = 0
+		}
+		attempt++
+		if policy.MaxAttempts > 0 && attempt > policy.MaxAttempts {
+			s.logger.Error("Не удалось подключиться к серверу после %d попыток, стриминг прекращен", policy.MaxAttempts)
+			s.stop(ctx, fmt.Errorf("%w: %v", ErrReconnectAttemptsExhausted, err))
+			return
+		}
+
+		delay := policy.Delay(attempt)
+		if policy.MaxAttempts > 0 {
+			s.logger.Info("Переподключение к серверу через %v (попытка %d из %d)", delay.Round(time.Millisecond), attempt, policy.MaxAttempts)
+		} else {
+			s.logger.Info("Переподключение к серверу через %v (попытка %d)", delay.Round(time.Millisecond), attempt)
+		}
+		s.setStreamStatus(ctx, attempt, time.Now().Add(delay), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// stop сообщает через Done, что стриминг прекращен с ошибкой err
+func (s *WebcamService) stop(ctx context.Context, err error) {
+	s.setStreamStatus(ctx, 0, time.Time{}, err)
+	select {
+	case s.done <- err:
+	default:
+	}
+}
+
+// setStreamStatus обновляет состояние переподключения, если захват не остановлен
+func (s *WebcamService) setStreamStatus(ctx context.Context, attempt int, next time.Time, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	s.status.Attempt = attempt
+	s.status.NextAttempt = next
+	s.status.LastError = err.Error()
 }
 
 // StopCapture останавливает захват и стриминг
@@ -102,6 +247,7 @@ func (s *WebcamService) StopCapture() error {
 
 	s.activeTrack = nil
 	s.cancelFunc = nil
+	s.status = CaptureStatus{}
 
 	return nil
 }
@@ -1,10 +1,24 @@
 package domain
 
+import (
+	"errors"
+	"time"
+)
+
+// Причины, по которым прекращается передача видео на сервер
+var (
+	ErrConnectionLost  = errors.New("соединение с сервером потеряно") // обрыв во время передачи
+	ErrClosedByServer  = errors.New("сервер закрыл соединение")       // сервер сам отключил клиента
+	ErrStreamTakenOver = errors.New("поток перехвачен другим издателем")
+)
+
 // VideoFrame представляет кадр видео
 type VideoFrame struct {
-	Data   []byte // Данные кадра в H.264 формате
-	Size   int    // Размер данных в байтах
-	Number int    // Номер кадра
+	Data      []byte        // Данные кадра в H.264 формате
+	Size      int           // Размер данных в байтах
+	Number    int           // Номер кадра
+	Timestamp time.Duration // Время захвата от начала потока
+	Keyframe  bool          // Кадр содержит IDR-слайс
 }
 
 // VideoDevice представляет устройство захвата видео
@@ -23,6 +37,8 @@ type VideoConfig struct {
 	DeviceID     string // ID устройства для захвата
 	CodecName    string // Имя кодека (например, "h264")
 	StreamingURL string // URL для стриминга
+	AuthToken    string // Токен авторизации на сервере (опционально)
+	ClientID     string // Идентификатор клиента, который сообщается серверу
 }
 
 // VideoReader интерфейс для чтения видеокадров
@@ -31,6 +47,13 @@ type VideoReader interface {
 	Close() error
 }
 
+// EncoderControl управляет работающим кодировщиком. Реализуется ридером,
+// если кодировщик поддерживает изменение параметров на ходу.
+type EncoderControl interface {
+	ForceKeyFrame() error
+	SetBitRate(bitRate int) error
+}
+
 // VideoStreamer интерфейс для стриминга видео
 type VideoStreamer interface {
 	Connect() error
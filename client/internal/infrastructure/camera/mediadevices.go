@@ -1,9 +1,14 @@
 package camera
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/pion/mediadevices"
+	"github.com/pion/mediadevices/pkg/codec"
 	_ "github.com/pion/mediadevices/pkg/driver/camera" // Регистрируем драйвер камеры
 
 	// Регистрируем кодеки для видео
@@ -47,6 +52,7 @@ func (m *MediaDevicesManager) ListDevices() ([]domain.VideoDevice, error) {
 func (m *MediaDevicesManager) OpenCamera(config domain.VideoConfig) (domain.VideoTrack, error) {
 	// Настройка опций видеокодирования
 	var codecSelector *mediadevices.CodecSelector
+	var encoderParams *x264.Params
 
 	// Создаем селектор кодеков
 	// vpxParams, err := vpx.NewVP8Params()
@@ -62,8 +68,9 @@ func (m *MediaDevicesManager) OpenCamera(config domain.VideoConfig) (domain.Vide
 		x264Params, err := x264.NewParams()
 		if err == nil {
 			x264Params.BitRate = int(config.BitRate)
+			encoderParams = &x264Params
 			codecSelector = mediadevices.NewCodecSelector(
-				mediadevices.WithVideoEncoders(&x264Params),
+				mediadevices.WithVideoEncoders(encoderParams),
 			)
 		}
 	}
@@ -116,6 +123,7 @@ func (m *MediaDevicesManager) OpenCamera(config domain.VideoConfig) (domain.Vide
 
 	return &MediaDevicesTrack{
 		track:  videoTracks[0],
+		params: encoderParams,
 		logger: m.logger,
 	}, nil
 }
@@ -123,6 +131,7 @@ func (m *MediaDevicesManager) OpenCamera(config domain.VideoConfig) (domain.Vide
 // MediaDevicesTrack обертка для MediaDevices Track
 type MediaDevicesTrack struct {
 	track  mediadevices.Track
+	params *x264.Params // параметры, с которыми селектор кодеков создает кодировщик x264
 	logger application.Logger
 }
 
@@ -138,36 +147,50 @@ func (t *MediaDevicesTrack) Close() error {
 
 // CreateReader создает ридер для чтения видеокадров
 func (t *MediaDevicesTrack) CreateReader() (domain.VideoReader, error) {
-	// Используем простой кодек, который точно доступен
-	reader, err := t.track.NewEncodedIOReader("vp8")
+	reader, err := t.newEncodedReader()
 	if err != nil {
-		// Если VP8 недоступен, попробуем работать с необработанными данными
-		t.logger.Info("VP8 кодек недоступен, пробуем H264...")
-		reader, err = t.track.NewEncodedIOReader("h264")
-
-		if err != nil {
-			t.logger.Error("Ошибка создания ридера: %v", err)
-			return nil, err
-		}
+		t.logger.Error("Ошибка создания ридера: %v", err)
+		return nil, err
 	}
 
 	return &MediaDevicesReader{
+		track:       t,
 		reader:      reader,
 		logger:      t.logger,
 		frameNumber: 0,
 	}, nil
 }
 
-// MediaDevicesReader обертка для MediaDevices Reader
+// newEncodedReader запускает кодировщик трека
+func (t *MediaDevicesTrack) newEncodedReader() (io.ReadCloser, error) {
+	// Используем простой кодек, который точно доступен
+	reader, err := t.track.NewEncodedIOReader("vp8")
+	if err != nil {
+		// Если VP8 недоступен, попробуем работать с необработанными данными
+		t.logger.Info("VP8 кодек недоступен, пробуем H264...")
+		reader, err = t.track.NewEncodedIOReader("h264")
+	}
+	return reader, err
+}
+
+// MediaDevicesReader обертка для MediaDevices Reader.
+// Реализует domain.EncoderControl: команды сервера применяются к работающему кодировщику.
 type MediaDevicesReader struct {
+	mutex       sync.Mutex
+	track       *MediaDevicesTrack
 	reader      io.ReadCloser
 	logger      application.Logger
 	frameNumber int
 	buffer      []byte
+	startTime   time.Time // время первого кадра, от которого отсчитываются метки времени
 }
 
 // Read читает следующий кадр
 func (r *MediaDevicesReader) Read() (*domain.VideoFrame, error) {
+	// Кодировщик может быть заменен при смене битрейта, поэтому чтение идет под блокировкой
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	if r.buffer == nil {
 		r.buffer = make([]byte, 1024*1024) // 1MB буфер для кадра
 	}
@@ -181,15 +204,23 @@ func (r *MediaDevicesReader) Read() (*domain.VideoFrame, error) {
 	}
 
 	if n > 0 {
+		// Кодировщик отдает кадр сразу после захвата, поэтому время чтения служит временем захвата
+		now := time.Now()
+		if r.startTime.IsZero() {
+			r.startTime = now
+		}
+
 		r.frameNumber++
 		// Копируем данные, чтобы избежать проблем с перезаписью буфера
 		data := make([]byte, n)
 		copy(data, r.buffer[:n])
 
 		return &domain.VideoFrame{
-			Data:   data,
-			Size:   n,
-			Number: r.frameNumber,
+			Data:      data,
+			Size:      n,
+			Number:    r.frameNumber,
+			Timestamp: now.Sub(r.startTime),
+			Keyframe:  containsIDR(data),
 		}, nil
 	}
 
@@ -198,5 +229,70 @@ func (r *MediaDevicesReader) Read() (*domain.VideoFrame, error) {
 
 // Close закрывает ридер
 func (r *MediaDevicesReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	return r.reader.Close()
 }
+
+// ForceKeyFrame просит кодировщик закодировать следующий кадр как ключевой
+func (r *MediaDevicesReader) ForceKeyFrame() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	controller, ok := r.controller().(codec.KeyFrameController)
+	if !ok {
+		return errors.New("кодировщик не поддерживает принудительный ключевой кадр")
+	}
+	return controller.ForceKeyFrame()
+}
+
+// SetBitRate меняет целевой битрейт кодировщика. x264 не умеет менять битрейт на ходу,
+// поэтому кодировщик пересоздается с новыми параметрами: камера при этом не закрывается,
+// а новый кодировщик начинает с ключевого кадра.
+func (r *MediaDevicesReader) SetBitRate(bitRate int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if controller, ok := r.controller().(codec.BitRateController); ok {
+		return controller.SetBitRate(bitRate)
+	}
+	if r.track.params == nil {
+		return errors.New("кодировщик не поддерживает изменение битрейта")
+	}
+
+	previous := r.track.params.BitRate
+	r.track.params.BitRate = bitRate
+	reader, err := r.track.newEncodedReader()
+	if err != nil {
+		r.track.params.BitRate = previous
+		return err
+	}
+	if err := r.reader.Close(); err != nil {
+		r.logger.Error("Ошибка закрытия прежнего кодировщика: %v", err)
+	}
+	r.reader = reader
+	return nil
+}
+
+// controller возвращает интерфейс управления кодировщиком или nil
+func (r *MediaDevicesReader) controller() codec.EncoderController {
+	if controllable, ok := r.reader.(codec.Controllable); ok {
+		return controllable.Controller()
+	}
+	return nil
+}
+
+// containsIDR проверяет, есть ли среди NAL-единиц Annex-B слайс IDR
+func containsIDR(data []byte) bool {
+	startCode := []byte{0, 0, 1}
+	for {
+		i := bytes.Index(data, startCode)
+		if i < 0 || i+len(startCode) >= len(data) {
+			return false
+		}
+		data = data[i+len(startCode):]
+		if data[0]&0x1f == 5 {
+			return true
+		}
+	}
+}
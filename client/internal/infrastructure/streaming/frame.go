@@ -0,0 +1,41 @@
+package streaming
+
+import (
+	"encoding/binary"
+	"time"
+
+	"webcam-transfer/client/internal/domain"
+)
+
+// Заголовок кадра в бинарном сообщении, big-endian. Используется, если сервер подтвердил
+// поле framed в accept; иначе кадры передаются сырым Annex-B.
+//
+//	0      версия формата (frameVersion)
+//	1      флаги (frameFlagKeyframe)
+//	2..3   идентификатор дорожки
+//	4..7   порядковый номер кадра
+//	8..15  время захвата от начала потока, мкс
+//	16..19 длина данных кадра
+const (
+	frameVersion      = 1
+	frameHeaderSize   = 20
+	frameFlagKeyframe = 0x01
+)
+
+// videoTrackID — дорожка с видео камеры
+const videoTrackID = 0
+
+// encodeFrame формирует сообщение из заголовка и данных кадра
+func encodeFrame(track uint16, sequence uint32, frame *domain.VideoFrame) []byte {
+	message := make([]byte, frameHeaderSize+len(frame.Data))
+	message[0] = frameVersion
+	if frame.Keyframe {
+		message[1] |= frameFlagKeyframe
+	}
+	binary.BigEndian.PutUint16(message[2:4], track)
+	binary.BigEndian.PutUint32(message[4:8], sequence)
+	binary.BigEndian.PutUint64(message[8:16], uint64(frame.Timestamp/time.Microsecond))
+	binary.BigEndian.PutUint32(message[16:20], uint32(len(frame.Data)))
+	copy(message[frameHeaderSize:], frame.Data)
+	return message
+}
@@ -0,0 +1,41 @@
+package streaming
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"webcam-transfer/client/internal/domain"
+)
+
+func TestEncodeFrame(t *testing.T) {
+	data := []byte{0, 0, 0, 1, 0x65, 0x88}
+	frame := &domain.VideoFrame{
+		Data:      data,
+		Size:      len(data),
+		Timestamp: 0x0102030405*time.Microsecond + 999*time.Nanosecond,
+		Keyframe:  true,
+	}
+
+	// Заголовок должен совпадать с разбором на сервере байт в байт
+	want := []byte{
+		0x01,       // версия
+		0x01,       // флаги: ключевой кадр
+		0x12, 0x34, // дорожка
+		0xde, 0xad, 0xbe, 0xef, // порядковый номер
+		0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, // время в мкс, доли отбрасываются
+		0x00, 0x00, 0x00, 0x06, // длина данных
+		0, 0, 0, 1, 0x65, 0x88,
+	}
+	if got := encodeFrame(0x1234, 0xdeadbeef, frame); !bytes.Equal(got, want) {
+		t.Errorf("encodeFrame = % x,\nожидалось % x", got, want)
+	}
+
+	frame.Keyframe = false
+	frame.Data = nil
+	frame.Timestamp = 0
+	want = []byte{0x01, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if got := encodeFrame(videoTrackID, 0, frame); !bytes.Equal(got, want) {
+		t.Errorf("пустой кадр: encodeFrame = % x,\nожидалось % x", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"webcam-transfer/client/internal/domain"
+)
+
+// protocolVersion — версия протокола управления, которую поддерживает клиент
+const protocolVersion = 1
+
+// acceptTimeout — сколько ждать ответа сервера на hello. Сервер, не ответивший за это время,
+// не поддерживает протокол управления, и видео передается в прежнем режиме.
+const acceptTimeout = 5 * time.Second
+
+// SoftwareVersion — версия клиента, о которой он сообщает серверу. Задается при сборке:
+// go build -ldflags "-X webcam-transfer/client/internal/infrastructure/streaming.SoftwareVersion=1.0.0"
+var SoftwareVersion = "dev"
+
+// Типы управляющих сообщений (текстовые сообщения WebSocket в JSON)
+const (
+	messageHello  = "hello"
+	messageAccept = "accept"
+	messageError  = "error"
+	messageAck    = "ack"
+
+	// Команды сервера
+	messageForceIDR   = "force_idr"
+	messageSetBitrate = "set_bitrate"
+	messagePause      = "pause"
+	messageResume     = "resume"
+)
+
+// controlMessage — общая часть всех управляющих сообщений
+type controlMessage struct {
+	Type string `json:"type"`
+}
+
+// helloMessage — первое сообщение клиента с его параметрами
+type helloMessage struct {
+	Type     string `json:"type"`
+	Version  int    `json:"version"`
+	ClientID string `json:"client_id,omitempty"`
+	Codec    string `json:"codec"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	FPS      int    `json:"fps,omitempty"`
+	Bitrate  int    `json:"bitrate,omitempty"`
+	Software string `json:"software,omitempty"`
+	Framed   bool   `json:"framed,omitempty"`
+
+	Resumable bool           `json:"resumable,omitempty"`
+	Resume    *resumeRequest `json:"resume,omitempty"`
+}
+
+// resumeRequest — прерванная сессия, которую клиент продолжает после переподключения
+type resumeRequest struct {
+	SessionID    string `json:"session_id"`
+	Key          string `json:"key"`           // ключ возобновления из accept
+	LastSequence uint32 `json:"last_sequence"` // последний кадр, подтвержденный сервером
+}
+
+// acceptMessage — ответ сервера: сессия принята
+type acceptMessage struct {
+	Type      string `json:"type"`
+	Version   int    `json:"version"`
+	SessionID string `json:"session_id"`
+	Framed    bool   `json:"framed,omitempty"` // сервер ждет кадры с заголовком
+	Limits    *struct {
+		MaxMessageSize int64 `json:"max_message_size,omitempty"`
+		MaxBitrate     int64 `json:"max_bitrate,omitempty"`
+	} `json:"limits,omitempty"`
+
+	Resumed      bool    `json:"resumed,omitempty"`       // сервер продолжил сессию из hello.resume
+	LastSequence *uint32 `json:"last_sequence,omitempty"` // последний кадр, полученный сервером до обрыва
+	ResumeGrace  int     `json:"resume_grace,omitempty"`  // сколько секунд сервер ждет возобновления
+	ResumeKey    string  `json:"resume_key,omitempty"`    // секрет, без которого сессию не возобновить
+}
+
+// ackMessage — номер последнего кадра, полученного сервером
+type ackMessage struct {
+	Type     string `json:"type"`
+	Sequence uint32 `json:"sequence"`
+}
+
+// errorMessage — ошибка, о которой сообщил сервер
+type errorMessage struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// commandMessage — команда сервера кодировщику или передаче видео
+type commandMessage struct {
+	Type    string `json:"type"`
+	Bitrate int    `json:"bitrate,omitempty"`
+}
+
+// closeStreamTakenOver — код закрытия, с которым сервер отключает издателя, чей поток перехватил другой клиент
+const closeStreamTakenOver = 4001
+
+// ServerError — ошибка, полученная от сервера в управляющем сообщении
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("сервер вернул ошибку %s: %s", e.Code, e.Message)
+}
+
+// handshake отправляет hello и ждет accept. Если сервер не ответил за acceptTimeout,
+// возвращается nil без ошибки: сервер работает без протокола управления.
+// resume, если задан, просит сервер продолжить прерванную сессию.
+func handshake(conn *websocket.Conn, config domain.VideoConfig, resume *resumeRequest) (*acceptMessage, error) {
+	hello, err := json.Marshal(helloMessage{
+		Type:     messageHello,
+		Version:  protocolVersion,
+		ClientID: config.ClientID,
+		Codec:    config.CodecName,
+		Width:    config.Width,
+		Height:   config.Height,
+		FPS:      config.FrameRate,
+		Bitrate:  config.BitRate,
+		Software: SoftwareVersion,
+		Framed:   true,
+
+		Resumable: true,
+		Resume:    resume,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, hello); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(acceptTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		messageType, data, err := conn.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		var message controlMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, fmt.Errorf("некорректный ответ сервера: %v", err)
+		}
+		switch message.Type {
+		case messageAccept:
+			accept := &acceptMessage{}
+			if err := json.Unmarshal(data, accept); err != nil {
+				return nil, fmt.Errorf("некорректный ответ сервера: %v", err)
+			}
+			return accept, nil
+		case messageError:
+			return nil, parseServerError(data)
+		}
+	}
+}
+
+// parseServerError разбирает сообщение error
+func parseServerError(data []byte) error {
+	var message errorMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return fmt.Errorf("некорректный ответ сервера: %v", err)
+	}
+	return &ServerError{Code: message.Code, Message: message.Message}
+}
@@ -0,0 +1,51 @@
+package streaming
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions задает параметры защищенного соединения с сервером
+type TLSOptions struct {
+	CAFile             string // файл с сертификатами доверенных УЦ (PEM)
+	InsecureSkipVerify bool   // не проверять сертификат сервера
+	CertFile           string // клиентский сертификат камеры (PEM)
+	KeyFile            string // закрытый ключ клиентского сертификата (PEM)
+}
+
+// NewTLSConfig создает конфигурацию TLS для подключения к серверу
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать файл УЦ: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("в файле УЦ нет сертификатов")
+		}
+		config.RootCAs = pool
+	}
+
+	// Клиентский сертификат удостоверяет камеру перед сервером
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, errors.New("нужно указать и клиентский сертификат, и ключ")
+		}
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось загрузить клиентский сертификат: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
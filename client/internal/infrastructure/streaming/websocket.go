@@ -2,6 +2,11 @@ package streaming
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
@@ -12,8 +17,25 @@ import (
 	"webcam-transfer/client/internal/domain"
 )
 
+// videoConfigHeader — заголовок, в котором сервер получает параметры видео в JSON
+const videoConfigHeader = "X-Video-Config"
+
+// closeWaitTimeout — сколько после ошибки отправки ждать кадра закрытия от сервера
+const closeWaitTimeout = time.Second
+
+// videoConfigInfo — параметры видео, о которых клиент сообщает серверу
+type videoConfigInfo struct {
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	FrameRate int    `json:"frame_rate,omitempty"`
+	BitRate   int    `json:"bit_rate,omitempty"`
+	Codec     string `json:"codec,omitempty"`
+	DeviceID  string `json:"device_id,omitempty"`
+}
+
 // WebSocketStreamer реализует стриминг видео через WebSocket
 type WebSocketStreamer struct {
+	dialer       *websocket.Dialer
 	conn         *websocket.Conn
 	logger       application.Logger
 	connected    bool
@@ -21,11 +43,29 @@ type WebSocketStreamer struct {
 	frameCounter int
 	startTime    time.Time
 	debugMode    bool
+	encoder      domain.EncoderControl // кодировщик текущего ридера, если он принимает команды
+	paused       bool                  // сервер приостановил передачу видео
+	framed       bool                  // кадры передаются с заголовком (сервер подтвердил в accept)
+	sequence     uint32                // порядковый номер следующего кадра
+
+	// Сессия, которую можно продолжить после обрыва соединения
+	sessionID   string
+	resumeKey   string
+	lastAck     uint32        // последний кадр, подтвержденный сервером
+	resumeGrace time.Duration // сколько сервер ждет возобновления сессии
+	droppedAt   time.Time     // время обрыва соединения
+
+	closedBy *websocket.CloseError // закрытие, полученное от сервера по текущему соединению
 }
 
-// NewWebSocketStreamer создает новый WebSocket стример
-func NewWebSocketStreamer(logger application.Logger, debugMode bool) *WebSocketStreamer {
+// NewWebSocketStreamer создает новый WebSocket стример.
+// tlsConfig используется для подключений wss:// и может быть nil.
+func NewWebSocketStreamer(logger application.Logger, debugMode bool, tlsConfig *tls.Config) *WebSocketStreamer {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+
 	return &WebSocketStreamer{
+		dialer:    &dialer,
 		logger:    logger,
 		debugMode: debugMode,
 	}
@@ -50,21 +90,81 @@ func (s *WebSocketStreamer) StartStreaming(ctx context.Context, track domain.Vid
 		return err
 	}
 
+	// Передаем токен авторизации в заголовке
+	header := http.Header{}
+	if config.AuthToken != "" {
+		header.Set("Authorization", "Bearer "+config.AuthToken)
+	}
+
+	// Сообщаем серверу параметры видео для метаданных записи
+	info, err := json.Marshal(videoConfigInfo{
+		Width:     config.Width,
+		Height:    config.Height,
+		FrameRate: config.FrameRate,
+		BitRate:   config.BitRate,
+		Codec:     config.CodecName,
+		DeviceID:  config.DeviceID,
+	})
+	if err == nil {
+		header.Set(videoConfigHeader, string(info))
+	}
+
 	s.logger.Info("Подключение к %s", u.String())
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, resp, err := s.dialer.Dial(u.String(), header)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		s.logger.Error("Сервер отклонил токен авторизации")
+	}
 	if err != nil {
 		s.logger.Error("Ошибка подключения к серверу: %v", err)
 		s.mutex.Unlock()
 		return err
 	}
 
+	// Представляемся серверу; сервер без протокола управления не отвечает на hello
+	resume := s.resumeRequest()
+	accept, err := handshake(conn, config, resume)
+	if err != nil {
+		s.logger.Error("Сервер отклонил подключение: %v", err)
+		conn.Close()
+		s.mutex.Unlock()
+		return err
+	}
+
 	s.conn = conn
 	s.connected = true
+	s.closedBy = nil
+	s.paused = false
+	s.framed = accept != nil && accept.Framed
+	resumed := accept != nil && accept.Resumed
+	if !resumed {
+		s.sequence = 0
+	}
+	s.rememberSession(accept)
 	s.frameCounter = 0
 	s.startTime = time.Now()
+	sequence := s.sequence
 	s.mutex.Unlock()
 
-	s.logger.Info("Подключено к серверу")
+	// controlDone закрывается, когда сервер закрыл соединение или оно оборвалось
+	var controlDone chan struct{}
+	switch {
+	case resumed:
+		s.logger.Info("Сессия %s возобновлена, потеряно кадров при обрыве: %d", accept.SessionID, lostFrames(accept.LastSequence, sequence))
+	case accept != nil:
+		if resume != nil {
+			s.logger.Info("Сервер не продолжил сессию %s, начата новая", resume.SessionID)
+		}
+		s.logger.Info("Подключено к серверу, сессия %s (протокол v%d, кадры с заголовком: %v)", accept.SessionID, accept.Version, accept.Framed)
+	default:
+		s.logger.Info("Подключено к серверу без протокола управления")
+	}
+	if accept != nil {
+		controlDone = make(chan struct{})
+		go func() {
+			defer close(controlDone)
+			s.readControl(conn)
+		}()
+	}
 
 	// Создаем ридер для чтения видеокадров
 	reader, err := track.CreateReader()
@@ -75,6 +175,12 @@ func (s *WebSocketStreamer) StartStreaming(ctx context.Context, track domain.Vid
 	}
 	defer reader.Close()
 
+	// Команды сервера применяются к кодировщику, если он их поддерживает
+	if encoder, ok := reader.(domain.EncoderControl); ok {
+		s.setEncoder(encoder)
+		defer s.setEncoder(nil)
+	}
+
 	s.logger.Info("Начало стриминга видео...")
 
 	// Стриминг кадров
@@ -91,20 +197,220 @@ func (s *WebSocketStreamer) StartStreaming(ctx context.Context, track domain.Vid
 				return err
 			}
 
-			if frame == nil {
+			// На паузе камера продолжает работать, но кадры не отправляются
+			if frame == nil || s.isPaused() {
 				continue
 			}
 
-			// Отправляем кадр
+			// Отправляем кадр. Оборванное соединение закрывается без кадра закрытия,
+			// чтобы сервер ждал возобновления сессии
 			err = s.sendFrame(frame)
 			if err != nil {
 				s.logger.Error("Ошибка отправки кадра: %v", err)
-				return err
+				// Кадр закрытия от сервера мог еще не быть прочитан: даем ему дойти,
+				// чтобы отличить отключение сервером от обрыва
+				if controlDone != nil {
+					select {
+					case <-controlDone:
+					case <-time.After(closeWaitTimeout):
+					}
+				}
+				return s.drop(err)
+			}
+		}
+	}
+}
+
+// readControl читает управляющие сообщения сервера, пока соединение открыто
+func (s *WebSocketStreamer) readControl(conn *websocket.Conn) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure {
+				// Сервер завершил сессию сам, продолжить ее нельзя
+				s.logger.Info("Сервер закрыл соединение: %s (код %d)", closeErr.Text, closeErr.Code)
+				s.closedByServer(conn, closeErr)
 			}
+			return
+		}
+		if messageType == websocket.TextMessage {
+			s.handleControl(data)
 		}
 	}
 }
 
+// handleControl обрабатывает управляющее сообщение сервера.
+// Неизвестные типы пропускаются, чтобы клиент мог работать с более новым сервером.
+func (s *WebSocketStreamer) handleControl(data []byte) {
+	var message controlMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		s.logger.Error("Некорректное управляющее сообщение сервера: %v", err)
+		return
+	}
+
+	switch message.Type {
+	case messageAck:
+		var ack ackMessage
+		if err := json.Unmarshal(data, &ack); err != nil {
+			s.logger.Error("Некорректное подтверждение кадров от сервера: %v", err)
+			return
+		}
+		s.setAck(ack.Sequence)
+	case messageError:
+		s.logger.Error("%v", parseServerError(data))
+	case messageForceIDR:
+		s.forceKeyFrame()
+	case messageSetBitrate:
+		var command commandMessage
+		if err := json.Unmarshal(data, &command); err != nil || command.Bitrate <= 0 {
+			s.logger.Error("Некорректная команда %s от сервера", message.Type)
+			return
+		}
+		s.setBitRate(command.Bitrate)
+	case messagePause:
+		s.setPaused(true)
+		s.logger.Info("Сервер приостановил передачу видео")
+	case messageResume:
+		s.setPaused(false)
+		s.logger.Info("Сервер возобновил передачу видео")
+		// Сервер не сможет декодировать поток до ключевого кадра
+		s.forceKeyFrame()
+	default:
+		s.logger.Debug("Пропущено управляющее сообщение %q", message.Type)
+	}
+}
+
+// resumeRequest возвращает сессию, которую можно продолжить после обрыва, или nil.
+// Вызывается под блокировкой s.mutex.
+func (s *WebSocketStreamer) resumeRequest() *resumeRequest {
+	if s.sessionID == "" || time.Since(s.droppedAt) >= s.resumeGrace {
+		return nil
+	}
+	return &resumeRequest{SessionID: s.sessionID, Key: s.resumeKey, LastSequence: s.lastAck}
+}
+
+// rememberSession запоминает сессию из accept, если сервер готов ее возобновлять.
+// Вызывается под блокировкой s.mutex.
+func (s *WebSocketStreamer) rememberSession(accept *acceptMessage) {
+	if accept == nil || !accept.Resumed {
+		s.lastAck = 0
+	}
+	s.sessionID = ""
+	s.resumeKey = ""
+	s.resumeGrace = 0
+	if accept == nil || accept.ResumeGrace <= 0 || accept.ResumeKey == "" {
+		return
+	}
+	s.sessionID = accept.SessionID
+	s.resumeKey = accept.ResumeKey
+	s.resumeGrace = time.Duration(accept.ResumeGrace) * time.Second
+}
+
+// closedByServer запоминает закрытие, полученное по соединению conn, и отказывается
+// от возобновления сессии, которую сервер завершил. Закрытие прежнего соединения не учитывается.
+func (s *WebSocketStreamer) closedByServer(conn *websocket.Conn, closeErr *websocket.CloseError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == conn {
+		s.sessionID = ""
+		s.closedBy = closeErr
+	}
+}
+
+// setAck запоминает последний кадр, подтвержденный сервером
+func (s *WebSocketStreamer) setAck(sequence uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastAck = sequence
+}
+
+// lostFrames считает кадры, отправленные до обрыва, но не полученные сервером
+func lostFrames(lastSequence *uint32, next uint32) uint32 {
+	if lastSequence == nil {
+		return next
+	}
+	return next - *lastSequence - 1
+}
+
+// drop закрывает соединение после ошибки отправки err, не завершая сессию на сервере,
+// и возвращает причину прекращения передачи: закрытие сервером или обрыв
+func (s *WebSocketStreamer) drop(err error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.connected = false
+	s.droppedAt = time.Now()
+
+	switch closedBy := s.closedBy; {
+	case closedBy == nil:
+		return fmt.Errorf("%w: %v", domain.ErrConnectionLost, err)
+	case closedBy.Code == closeStreamTakenOver:
+		return fmt.Errorf("%w: %s", domain.ErrStreamTakenOver, closedBy.Text)
+	default:
+		return fmt.Errorf("%w: %s (код %d)", domain.ErrClosedByServer, closedBy.Text, closedBy.Code)
+	}
+}
+
+// setEncoder запоминает кодировщик, к которому применяются команды сервера
+func (s *WebSocketStreamer) setEncoder(encoder domain.EncoderControl) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.encoder = encoder
+}
+
+// currentEncoder возвращает кодировщик текущего ридера или nil
+func (s *WebSocketStreamer) currentEncoder() domain.EncoderControl {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.encoder
+}
+
+// setPaused приостанавливает или возобновляет отправку кадров
+func (s *WebSocketStreamer) setPaused(paused bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.paused = paused
+}
+
+// isPaused сообщает, приостановлена ли отправка кадров
+func (s *WebSocketStreamer) isPaused() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.paused
+}
+
+// forceKeyFrame просит кодировщик закодировать ключевой кадр
+func (s *WebSocketStreamer) forceKeyFrame() {
+	encoder := s.currentEncoder()
+	if encoder == nil {
+		s.logger.Debug("Кодировщик не поддерживает команды, ключевой кадр не запрошен")
+		return
+	}
+	if err := encoder.ForceKeyFrame(); err != nil {
+		s.logger.Error("Не удалось запросить ключевой кадр: %v", err)
+		return
+	}
+	s.logger.Debug("Сервер запросил ключевой кадр")
+}
+
+// setBitRate меняет битрейт кодировщика по команде сервера
+func (s *WebSocketStreamer) setBitRate(bitRate int) {
+	encoder := s.currentEncoder()
+	if encoder == nil {
+		s.logger.Error("Кодировщик не поддерживает команды, битрейт не изменен")
+		return
+	}
+	if err := encoder.SetBitRate(bitRate); err != nil {
+		s.logger.Error("Не удалось изменить битрейт: %v", err)
+		return
+	}
+	s.logger.Info("Битрейт изменен сервером: %d bps", bitRate)
+}
+
 // StopStreaming останавливает стриминг
 func (s *WebSocketStreamer) StopStreaming() error {
 	s.mutex.Lock()
@@ -127,6 +433,7 @@ func (s *WebSocketStreamer) StopStreaming() error {
 	s.conn.Close()
 	s.conn = nil
 	s.connected = false
+	s.sessionID = ""
 
 	return nil
 }
@@ -147,7 +454,18 @@ func (s *WebSocketStreamer) SendFrame(frame *domain.VideoFrame) error {
 		return nil
 	}
 
-	return s.conn.WriteMessage(websocket.BinaryMessage, frame.Data)
+	return s.conn.WriteMessage(websocket.BinaryMessage, s.encode(frame))
+}
+
+// encode формирует сообщение с кадром в согласованном с сервером формате.
+// Вызывается под блокировкой s.mutex.
+func (s *WebSocketStreamer) encode(frame *domain.VideoFrame) []byte {
+	if !s.framed {
+		return frame.Data
+	}
+	message := encodeFrame(videoTrackID, s.sequence, frame)
+	s.sequence++
+	return message
 }
 
 // sendFrame внутренний метод для отправки кадра
@@ -159,7 +477,7 @@ func (s *WebSocketStreamer) sendFrame(frame *domain.VideoFrame) error {
 		return nil
 	}
 
-	err := s.conn.WriteMessage(websocket.BinaryMessage, frame.Data)
+	err := s.conn.WriteMessage(websocket.BinaryMessage, s.encode(frame))
 	if err != nil {
 		return err
 	}
@@ -3,13 +3,19 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"webcam-transfer/client/internal/application"
 	"webcam-transfer/client/internal/domain"
 )
 
+// statusInterval — как часто CLI проверяет состояние передачи, чтобы сообщить о восстановлении соединения
+const statusInterval = time.Second
+
 // CLI представляет CLI интерфейс приложения
 type CLI struct {
 	webcamService *application.WebcamService
@@ -27,6 +33,19 @@ type Config struct {
 	Debug       bool
 	ListDevices bool
 	DeviceID    string
+	Token       string
+	TokenFile   string
+	TLS         bool
+	CAFile      string
+	Insecure    bool
+	CertFile    string
+	KeyFile     string
+	StreamID    string
+	ClientID    string
+
+	ReconnectInterval    time.Duration
+	ReconnectMaxInterval time.Duration
+	ReconnectAttempts    int
 }
 
 // NewCLI создает новый CLI интерфейс
@@ -54,6 +73,22 @@ func (c *CLI) ParseFlags() *Config {
 	flag.BoolVar(&config.Debug, "debug", false, "включить отладочные сообщения")
 	flag.BoolVar(&config.ListDevices, "list-devices", false, "показать список доступных камер и выйти")
 	flag.StringVar(&config.DeviceID, "device", "", "ID устройства камеры для использования")
+	flag.StringVar(&config.Token, "token", "", "токен авторизации на сервере")
+	flag.StringVar(&config.TokenFile, "token-file", "", "файл с токеном авторизации на сервере")
+	flag.BoolVar(&config.TLS, "tls", false, "подключаться к серверу по защищенному протоколу wss://")
+	flag.StringVar(&config.CAFile, "ca-file", "", "файл с сертификатом УЦ для проверки сервера (PEM)")
+	flag.BoolVar(&config.Insecure, "insecure-skip-verify", false, "не проверять сертификат сервера (только для отладки)")
+	flag.StringVar(&config.CertFile, "cert", "", "клиентский сертификат камеры (PEM) для взаимной аутентификации TLS")
+	flag.StringVar(&config.KeyFile, "key", "", "закрытый ключ клиентского сертификата (PEM)")
+	flag.StringVar(&config.StreamID, "stream-id", "", "идентификатор потока на сервере (записи сохраняются в поддиректорию с этим именем)")
+
+	reconnect := application.DefaultReconnectPolicy()
+	flag.DurationVar(&config.ReconnectInterval, "reconnect-interval", reconnect.InitialInterval, "интервал перед первой попыткой переподключения к серверу")
+	flag.DurationVar(&config.ReconnectMaxInterval, "reconnect-max-interval", reconnect.MaxInterval, "наибольший интервал между попытками переподключения")
+	flag.IntVar(&config.ReconnectAttempts, "reconnect-attempts", 0, "завершить работу с ошибкой после стольких неудачных попыток переподключения подряд (0 — без ограничения)")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&config.ClientID, "client-id", hostname, "идентификатор клиента, который сообщается серверу (по умолчанию имя хоста)")
 
 	flag.Parse()
 
@@ -68,11 +103,31 @@ func (c *CLI) Run() error {
 		return c.listDevices()
 	}
 
+	// Загружаем токен авторизации
+	token, err := c.loadToken()
+	if err != nil {
+		return err
+	}
+
+	reconnect, err := c.reconnectPolicy()
+	if err != nil {
+		return err
+	}
+
 	// Настраиваем обработку сигналов завершения
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 	// Обработка сигналов
 
+	scheme := "ws"
+	if c.config.TLS {
+		scheme = "wss"
+	}
+	streamingURL := fmt.Sprintf("%s://%s/ws", scheme, c.config.Address)
+	if c.config.StreamID != "" {
+		streamingURL += "/" + url.PathEscape(c.config.StreamID)
+	}
+
 	// Создаем конфигурацию для видеопотока
 	videoConfig := domain.VideoConfig{
 		Width:        c.config.Width,
@@ -81,21 +136,76 @@ func (c *CLI) Run() error {
 		BitRate:      c.config.BitRate,
 		DeviceID:     c.config.DeviceID,
 		CodecName:    "h264",
-		StreamingURL: fmt.Sprintf("ws://%s/ws", c.config.Address),
+		StreamingURL: streamingURL,
+		AuthToken:    token,
+		ClientID:     c.config.ClientID,
 	}
 
 	// Запускаем захват видео
-	err := c.webcamService.StartCapture(videoConfig)
+	c.webcamService.SetReconnectPolicy(reconnect)
+	err = c.webcamService.StartCapture(videoConfig)
 	if err != nil {
 		return err
 	}
 
-	// Ожидаем сигнала завершения
-	<-interrupt
-	c.logger.Info("Прерывание получено, закрытие...")
+	// Ожидаем сигнала завершения или отказа от переподключения, следя за состоянием передачи
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	status := c.webcamService.Status()
+	for {
+		select {
+		case <-interrupt:
+			c.logger.Info("Прерывание получено, закрытие...")
+			if current := c.webcamService.Status(); !current.Connected && current.Attempt > 0 {
+				c.logger.Info("Соединение с сервером так и не восстановлено: попытка %d, последняя ошибка: %s", current.Attempt, current.LastError)
+			}
+			// Останавливаем захват
+			return c.webcamService.StopCapture()
+		case err := <-c.webcamService.Done():
+			c.webcamService.StopCapture()
+			return err
+		case <-ticker.C:
+			current := c.webcamService.Status()
+			c.reportStatus(status, current)
+			status = current
+		}
+	}
+}
+
+// reportStatus сообщает в журнал о восстановлении передачи, если состояние сменилось с previous на current.
+// Об ошибках и попытках переподключения пишет сам сервис.
+func (c *CLI) reportStatus(previous, current application.CaptureStatus) {
+	if current.Connected && !previous.Connected && previous.Attempt > 0 {
+		c.logger.Info("Соединение с сервером восстановлено (попытка переподключения %d)", previous.Attempt)
+	}
+}
+
+// reconnectPolicy возвращает политику переподключения из флагов
+func (c *CLI) reconnectPolicy() (application.ReconnectPolicy, error) {
+	policy := application.DefaultReconnectPolicy()
+	if c.config.ReconnectInterval <= 0 || c.config.ReconnectMaxInterval < c.config.ReconnectInterval {
+		return policy, fmt.Errorf("интервал переподключения должен быть положительным и не больше наибольшего интервала")
+	}
+	if c.config.ReconnectAttempts < 0 {
+		return policy, fmt.Errorf("число попыток переподключения не может быть отрицательным")
+	}
+	policy.InitialInterval = c.config.ReconnectInterval
+	policy.MaxInterval = c.config.ReconnectMaxInterval
+	policy.MaxAttempts = c.config.ReconnectAttempts
+	return policy, nil
+}
+
+// loadToken возвращает токен из флага или из файла
+func (c *CLI) loadToken() (string, error) {
+	if c.config.TokenFile == "" {
+		return c.config.Token, nil
+	}
 
-	// Останавливаем захват
-	return c.webcamService.StopCapture()
+	data, err := os.ReadFile(c.config.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("не удалось прочитать файл токена: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
 }
 
 // listDevices выводит список доступных устройств
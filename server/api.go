@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"time"
+)
+
+// sessionInfo — описание активной сессии в ответах API
+type sessionInfo struct {
+	ID         string         `json:"id"`
+	ClientAddr string         `json:"client_addr"`
+	Identity   string         `json:"identity,omitempty"`
+	StreamID   string         `json:"stream_id,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	Connected  bool           `json:"connected"` // false — сессия ждет переподключения камеры
+	Reconnects int            `json:"reconnects,omitempty"`
+	WatchURL   string         `json:"watch_url"`
+	HLSURL     string         `json:"hls_url,omitempty"`
+	Video      *videoInfo     `json:"video,omitempty"`
+	Camera     *cameraState   `json:"camera,omitempty"` // только для клиентов с протоколом управления
+	Quality    *qualityReport `json:"quality"`
+}
+
+// sessionsHandler возвращает список активных сессий
+func sessionsHandler(sessions *SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list := []sessionInfo{}
+		for _, s := range sessions.List() {
+			list = append(list, newSessionInfo(s))
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// newSessionInfo описывает сессию для ответа API
+func newSessionInfo(s *Session) sessionInfo {
+	info := sessionInfo{
+		ID:         s.ID,
+		ClientAddr: s.ClientAddr,
+		Identity:   s.Identity,
+		StreamID:   s.StreamID,
+		StartedAt:  s.StartedAt,
+		Connected:  s.Connected(),
+		Reconnects: s.Reconnects(),
+		WatchURL:   "/watch/" + s.ID,
+		Video:      newVideoInfo(s.Video()),
+		Camera:     s.CameraState(),
+		Quality:    s.Quality(),
+	}
+	if s.live != nil {
+		info.HLSURL = "/live/" + s.ID + "/index.m3u8"
+	}
+	return info
+}
+
+// sessionQualityHandler возвращает текущие показатели качества доставки кадров сессии
+func sessionQualityHandler(sessions *SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := sessions.Get(r.PathValue("session"))
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("сессия не найдена"))
+			return
+		}
+		writeJSON(w, http.StatusOK, session.Quality())
+	}
+}
+
+// sessionCommandHandler отправляет камере сессии команду из тела запроса:
+// {"type": "force_idr"}, {"type": "set_bitrate", "bitrate": 500000}, {"type": "pause"} или {"type": "resume"}
+func sessionCommandHandler(sessions *SessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := sessions.Get(r.PathValue("session"))
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("сессия не найдена"))
+			return
+		}
+
+		var command commandMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&command); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("некорректная команда: %v", err))
+			return
+		}
+		if err := command.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err := session.Command(command)
+		switch {
+		case errors.Is(err, errControlUnsupported):
+			writeError(w, http.StatusConflict, err)
+			return
+		case err != nil:
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		log.Printf("Команда %s для сессии %s отправлена через API (%s)", command.Type, session.ID, r.RemoteAddr)
+		writeJSON(w, http.StatusOK, newSessionInfo(session))
+	}
+}
+
+// recordingsListHandler возвращает список записей с фильтрами from, to (RFC 3339), client, identity, stream и format
+func recordingsListHandler(store *RecordingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := RecordingFilter{
+			ClientAddr: query.Get("client"),
+			Identity:   query.Get("identity"),
+			StreamID:   query.Get("stream"),
+			Format:     query.Get("format"),
+		}
+
+		for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			value := query.Get(name)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("некорректный параметр %s: %v", name, err))
+				return
+			}
+			*dst = t
+		}
+
+		recordings, err := store.List(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, recordings)
+	}
+}
+
+// recordingDownloadHandler отдает файл записи с поддержкой HTTP Range
+func recordingDownloadHandler(store *RecordingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		file, err := store.Open(id)
+		if err != nil {
+			writeRecordingError(w, err)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		contentType := "video/h264"
+		if format, _ := recordingFormat(id); format == formatMP4 {
+			contentType = "video/mp4"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(id)))
+		http.ServeContent(w, r, path.Base(id), info.ModTime(), file)
+	}
+}
+
+// recordingDeleteHandler удаляет файл записи
+func recordingDeleteHandler(store *RecordingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if err := store.Delete(id); err != nil {
+			writeRecordingError(w, err)
+			return
+		}
+		log.Printf("Запись удалена через API: %s (%s)", id, r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// drainStatus — состояние режима вывода из работы в ответах API
+type drainStatus struct {
+	Draining bool `json:"draining"`
+	Sessions int  `json:"sessions"`
+}
+
+// drainHandler показывает (GET), включает (POST) или выключает (DELETE) режим вывода из работы.
+// При развертывании достаточно включить режим и ждать, пока sessions не станет равным нулю.
+func drainHandler(ingest *IngestHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			ingest.SetDraining(true)
+			log.Printf("Режим вывода из работы включен (%s)", r.RemoteAddr)
+		case http.MethodDelete:
+			ingest.SetDraining(false)
+			log.Printf("Режим вывода из работы выключен (%s)", r.RemoteAddr)
+		}
+
+		status := ingest.Status()
+		writeJSON(w, http.StatusOK, drainStatus{Draining: status.Draining, Sessions: status.Active})
+	}
+}
+
+// writeRecordingError переводит ошибку хранилища записей в HTTP-статус
+func writeRecordingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errRecordingNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, errRecordingActive):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// writeError отправляет ошибку в формате JSON
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON отправляет ответ в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Ошибка отправки ответа: %v", err)
+	}
+}
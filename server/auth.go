@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	errNoToken      = errors.New("токен не передан")
+	errInvalidToken = errors.New("недействительный токен")
+	errExpiredToken = errors.New("срок действия токена истек")
+	errForbidden    = errors.New("токен не дает доступа к API управления")
+)
+
+// scopeAdmin — область действия подписанного токена администратора
+const scopeAdmin = "admin"
+
+// tokenClaims — содержимое подписанного токена
+type tokenClaims struct {
+	Subject   string `json:"sub,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Authenticator проверяет токены клиентов, публикующих поток, и запросы к API управления.
+// Принимаются статические токены из файла и подписанные HMAC-SHA256 токены
+// с ограниченным сроком действия. Токен передается в заголовке
+// "Authorization: Bearer <токен>" или в параметре запроса token.
+// К API управления допускаются только токены администратора: статические из отдельного файла
+// и подписанные с областью действия admin. Токены камер дают доступ только к публикации потока.
+type Authenticator struct {
+	key         []byte
+	tokens      [][]byte
+	adminTokens [][]byte
+}
+
+// LoadAuthenticator загружает ключ подписи, статические токены камер и администраторов из файлов.
+// Если все пути пустые, авторизация отключена и возвращается nil.
+func LoadAuthenticator(keyFile, tokensFile, adminTokensFile string) (*Authenticator, error) {
+	if keyFile == "" && tokensFile == "" && adminTokensFile == "" {
+		return nil, nil
+	}
+
+	a := &Authenticator{}
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать ключ подписи: %v", err)
+		}
+		a.key = bytes.TrimSpace(key)
+		if len(a.key) < 16 {
+			return nil, errors.New("ключ подписи должен быть не короче 16 байт")
+		}
+	}
+
+	var err error
+	if tokensFile != "" {
+		if a.tokens, err = readTokens(tokensFile); err != nil {
+			return nil, err
+		}
+	}
+	if adminTokensFile != "" {
+		if a.adminTokens, err = readTokens(adminTokensFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// readTokens читает статические токены из файла, по одному в строке
+func readTokens(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл токенов: %v", err)
+	}
+	defer file.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, []byte(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл токенов: %v", err)
+	}
+	return tokens, nil
+}
+
+// Check проверяет токен запроса и возвращает субъект подписанного токена.
+// Публиковать поток можно и с токеном камеры, и с токеном администратора.
+func (a *Authenticator) Check(r *http.Request) (string, error) {
+	claims, err := a.authenticate(r)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// CheckAdmin проверяет, что запрос передал действующий токен администратора.
+// Для действующего токена камеры возвращается errForbidden.
+func (a *Authenticator) CheckAdmin(r *http.Request) error {
+	claims, err := a.authenticate(r)
+	if err != nil {
+		return err
+	}
+	if claims.Scope != scopeAdmin {
+		return errForbidden
+	}
+	return nil
+}
+
+// authenticate находит токен запроса и возвращает его содержимое.
+// Статическим токенам соответствует содержимое без субъекта.
+func (a *Authenticator) authenticate(r *http.Request) (*tokenClaims, error) {
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, errInvalidToken
+		}
+		token = strings.TrimSpace(value)
+	}
+	if token == "" {
+		return nil, errNoToken
+	}
+
+	if containsToken(a.adminTokens, token) {
+		return &tokenClaims{Scope: scopeAdmin}, nil
+	}
+	if containsToken(a.tokens, token) {
+		return &tokenClaims{}, nil
+	}
+
+	if a.key == nil {
+		return nil, errInvalidToken
+	}
+	return a.verify(token)
+}
+
+// containsToken сравнивает токен со статическими за постоянное время
+func containsToken(tokens [][]byte, token string) bool {
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(t, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueToken создает подписанный токен для субъекта со сроком действия ttl.
+// Непустая область действия scope ограничивает назначение токена, например scopeAdmin.
+func (a *Authenticator) IssueToken(subject, scope string, ttl time.Duration) (string, error) {
+	if a == nil || a.key == nil {
+		return "", errors.New("не задан ключ подписи")
+	}
+
+	payload, err := json.Marshal(tokenClaims{
+		Subject:   subject,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(a.sign(encoded)), nil
+}
+
+// verify проверяет подпись и срок действия токена
+func (a *Authenticator) verify(token string) (*tokenClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, a.sign(encoded)) {
+		return nil, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errExpiredToken
+	}
+	return claims, nil
+}
+
+// sign вычисляет подпись HMAC-SHA256
+func (a *Authenticator) sign(data string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// requireAuth пропускает запрос к handler, только если он передал действующий токен администратора.
+// Без токена или с недействительным токеном сервер отвечает 401, с токеном камеры — 403.
+// auth возвращает текущий Authenticator: он меняется при перезагрузке настроек,
+// а nil означает, что авторизация отключена и запросы пропускаются без проверки.
+func requireAuth(auth func() *Authenticator, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a := auth(); a != nil {
+			if err := a.CheckAdmin(r); err != nil {
+				log.Printf("Отклонен запрос %s %s от %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+				if errors.Is(err, errForbidden) {
+					writeError(w, http.StatusForbidden, err)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="webcam"`)
+				writeError(w, http.StatusUnauthorized, errors.New("требуется авторизация"))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
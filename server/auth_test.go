@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuthenticator создает Authenticator с ключом подписи, статическим токеном камеры
+// и статическим токеном администратора
+func testAuthenticator() *Authenticator {
+	return &Authenticator{
+		key:         []byte("0123456789abcdef0123"),
+		tokens:      [][]byte{[]byte("static-token")},
+		adminTokens: [][]byte{[]byte("admin-token")},
+	}
+}
+
+// signedToken подписывает произвольное содержимое токена ключом a
+func signedToken(t *testing.T, a *Authenticator, claims tokenClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(a.sign(encoded))
+}
+
+func TestAuthenticatorVerify(t *testing.T) {
+	a := testAuthenticator()
+	valid, err := a.IssueToken("camera-1", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, signature, _ := strings.Cut(valid, ".")
+	other := &Authenticator{key: []byte("fedcba9876543210fedc")}
+	foreign, err := other.IssueToken("camera-1", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		subject string
+		err     error
+	}{
+		{"действующий", valid, "camera-1", nil},
+		{"истекший", signedToken(t, a, tokenClaims{Subject: "camera-1", ExpiresAt: time.Now().Add(-time.Minute).Unix()}), "", errExpiredToken},
+		{"чужой ключ", foreign, "", errInvalidToken},
+		{"измененная подпись", encoded + "." + strings.ToUpper(signature), "", errInvalidToken},
+		{"измененное содержимое", base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"camera-2","exp":9999999999}`)) + "." + signature, "", errInvalidToken},
+		{"подпись не base64", encoded + ".!!!", "", errInvalidToken},
+		{"содержимое не base64", "!!!." + base64.RawURLEncoding.EncodeToString(a.sign("!!!")), "", errInvalidToken},
+		{"содержимое не JSON", "bm90LWpzb24." + base64.RawURLEncoding.EncodeToString(a.sign("bm90LWpzb24")), "", errInvalidToken},
+		{"без подписи", encoded, "", errInvalidToken},
+		{"пустой", "", "", errInvalidToken},
+	}
+	for _, tt := range tests {
+		claims, err := a.verify(tt.token)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: ошибка %v, ожидалась %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && claims.Subject != tt.subject {
+			t.Errorf("%s: субъект %q, ожидался %q", tt.name, claims.Subject, tt.subject)
+		}
+	}
+}
+
+func TestAuthenticatorCheck(t *testing.T) {
+	a := testAuthenticator()
+	signed, err := a.IssueToken("camera-1", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		header  string
+		subject string
+		err     error
+	}{
+		{"без токена", "", "", "", errNoToken},
+		{"статический в параметре", "?token=static-token", "", "", nil},
+		{"статический в заголовке", "", "Bearer static-token", "", nil},
+		{"статический администратора", "?token=admin-token", "", "", nil},
+		{"схема без учета регистра", "", "bearer static-token", "", nil},
+		{"неизвестный статический", "?token=static-token-2", "", "", errInvalidToken},
+		{"подписанный в параметре", "?token=" + signed, "", "camera-1", nil},
+		{"подписанный в заголовке", "", "Bearer " + signed, "camera-1", nil},
+		{"заголовок важнее параметра", "?token=static-token", "Bearer " + signed, "camera-1", nil},
+		{"неверный заголовок при верном параметре", "?token=static-token", "Bearer wrong", "", errInvalidToken},
+		{"другая схема", "?token=static-token", "Basic c3RhdGljLXRva2Vu", "", errInvalidToken},
+		{"заголовок без схемы", "", "static-token", "", errInvalidToken},
+		{"пустой Bearer", "?token=static-token", "Bearer ", "", errNoToken},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws"+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		subject, err := a.Check(r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: ошибка %v, ожидалась %v", tt.name, err, tt.err)
+			continue
+		}
+		if subject != tt.subject {
+			t.Errorf("%s: субъект %q, ожидался %q", tt.name, subject, tt.subject)
+		}
+	}
+}
+
+func TestAuthenticatorCheckWithoutKey(t *testing.T) {
+	a := &Authenticator{tokens: [][]byte{[]byte("static-token")}}
+	signed, err := testAuthenticator().IssueToken("camera-1", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Без ключа подписи принимаются только статические токены
+	r := httptest.NewRequest("GET", "/ws?token="+signed, nil)
+	if _, err := a.Check(r); !errors.Is(err, errInvalidToken) {
+		t.Errorf("подписанный токен без ключа: ошибка %v, ожидалась %v", err, errInvalidToken)
+	}
+	if _, err := a.IssueToken("camera-1", "", time.Hour); err == nil {
+		t.Error("выпуск токена без ключа должен завершаться ошибкой")
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	a := testAuthenticator()
+	camera, err := a.IssueToken("camera-1", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := a.IssueToken("ops", scopeAdmin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := signedToken(t, &Authenticator{key: []byte("fedcba9876543210fedc")}, tokenClaims{Scope: scopeAdmin, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	tests := []struct {
+		name   string
+		auth   *Authenticator
+		token  string
+		status int
+	}{
+		{"авторизация отключена", nil, "", http.StatusOK},
+		{"без токена", a, "", http.StatusUnauthorized},
+		{"недействительный", a, "wrong", http.StatusUnauthorized},
+		{"область admin с чужой подписью", a, forged, http.StatusUnauthorized},
+		{"статический камеры", a, "static-token", http.StatusForbidden},
+		{"подписанный камеры", a, camera, http.StatusForbidden},
+		{"статический администратора", a, "admin-token", http.StatusOK},
+		{"подписанный администратора", a, admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		handler := requireAuth(func() *Authenticator { return tt.auth }, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		r := httptest.NewRequest("DELETE", "/api/recordings/a.h264", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: статус %d, ожидался %d", tt.name, w.Code, tt.status)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (challenge != "") != (tt.status == http.StatusUnauthorized) {
+			t.Errorf("%s: WWW-Authenticate %q при статусе %d", tt.name, challenge, w.Code)
+		}
+	}
+}
+
+func TestLoadAuthenticatorAdminTokens(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin-tokens.txt")
+	if err := os.WriteFile(path, []byte("# администраторы\nadmin-token\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Одного файла токенов администраторов достаточно, чтобы включить авторизацию
+	a, err := LoadAuthenticator("", "", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == nil || len(a.tokens) != 0 || len(a.adminTokens) != 1 || string(a.adminTokens[0]) != "admin-token" {
+		t.Fatalf("загружено %+v", a)
+	}
+	if _, err := LoadAuthenticator("", "", filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("отсутствующий файл токенов администраторов: ожидалась ошибка")
+	}
+}
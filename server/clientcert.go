@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	errNoClientCert      = errors.New("требуется клиентский сертификат")
+	errRevokedClientCert = errors.New("клиентский сертификат отозван")
+)
+
+// maxIdentityLength ограничивает длину идентификатора камеры в именах файлов
+const maxIdentityLength = 64
+
+// ClientCertPolicy проверяет клиентские сертификаты камер и извлекает из них идентификатор потока.
+// Цепочку доверия проверяет сам TLS, здесь — только отзыв и запрет.
+type ClientCertPolicy struct {
+	required     bool
+	revoked      map[string]bool // серийные номера из CRL
+	deniedSerial map[string]bool // серийные номера из списка запретов
+	deniedCN     map[string]bool // CN из списка запретов
+}
+
+// LoadClientCertPolicy загружает CRL и список запретов.
+// Возвращает nil, если проверка клиентских сертификатов не настроена.
+func LoadClientCertPolicy(options TLSOptions) (*ClientCertPolicy, error) {
+	if options.ClientCAFile == "" {
+		if options.CRLFile != "" || options.DenyListFile != "" {
+			return nil, fmt.Errorf("для CRL и списка запретов нужен CA клиентов")
+		}
+		return nil, nil
+	}
+
+	cas, err := loadCertificates(options.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить CA клиентов: %v", err)
+	}
+
+	p := &ClientCertPolicy{
+		required:     options.RequireClientCert,
+		revoked:      make(map[string]bool),
+		deniedSerial: make(map[string]bool),
+		deniedCN:     make(map[string]bool),
+	}
+	if options.CRLFile != "" {
+		if err := p.loadCRL(options.CRLFile, cas); err != nil {
+			return nil, fmt.Errorf("не удалось загрузить CRL: %v", err)
+		}
+	}
+	if options.DenyListFile != "" {
+		if err := p.loadDenyList(options.DenyListFile); err != nil {
+			return nil, fmt.Errorf("не удалось загрузить список запретов: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// Identify возвращает идентификатор камеры из проверенного клиентского сертификата.
+// Пустая строка без ошибки означает, что сертификат не предъявлен и не обязателен.
+func (p *ClientCertPolicy) Identify(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		if p.required {
+			return "", errNoClientCert
+		}
+		return "", nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	serial := serialKey(cert.SerialNumber.Text(16))
+	if p.revoked[serial] || p.deniedSerial[serial] || p.deniedCN[cert.Subject.CommonName] {
+		return "", fmt.Errorf("%w: %s (серийный номер %s)", errRevokedClientCert, cert.Subject.CommonName, serial)
+	}
+
+	identity := sanitizeIdentity(cert.Subject.CommonName)
+	if identity == "" {
+		return "", fmt.Errorf("в клиентском сертификате нет CN")
+	}
+	return identity, nil
+}
+
+// loadCRL добавляет отозванные серийные номера из CRL, подписанного одним из CA клиентов
+func (p *ClientCertPolicy) loadCRL(path string, cas []*x509.Certificate) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// CRL может быть в PEM (один или несколько блоков) или в DER
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, data)
+	}
+
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return err
+		}
+		if !crlSignedBy(crl, cas) {
+			return fmt.Errorf("CRL %s не подписан CA клиентов", crl.Issuer)
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			p.revoked[serialKey(entry.SerialNumber.Text(16))] = true
+		}
+	}
+	return nil
+}
+
+// loadDenyList читает список запретов: по одной записи в строке, "serial:<hex>" или "cn:<имя>".
+// Вид записи указывается явно, чтобы CN из шестнадцатеричных цифр не запрещал одноименный серийный номер.
+func (p *ClientCertPolicy) loadDenyList(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			return fmt.Errorf("%s:%d: ожидается serial:<hex> или cn:<имя>", path, number)
+		case kind == "cn":
+			p.deniedCN[value] = true
+		case kind == "serial":
+			serial := serialKey(value)
+			if strings.Trim(serial, "0123456789abcdef") != "" {
+				return fmt.Errorf("%s:%d: некорректный серийный номер %s", path, number, value)
+			}
+			p.deniedSerial[serial] = true
+		default:
+			return fmt.Errorf("%s:%d: ожидается serial:<hex> или cn:<имя>", path, number)
+		}
+	}
+	return scanner.Err()
+}
+
+// crlSignedBy проверяет подпись CRL одним из доверенных CA
+func crlSignedBy(crl *x509.RevocationList, cas []*x509.Certificate) bool {
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCertificates читает все сертификаты из PEM-файла
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("в файле %s нет сертификатов", path)
+	}
+	return certs, nil
+}
+
+// serialKey приводит серийный номер к единому виду: hex в нижнем регистре без двоеточий и ведущих нулей
+func serialKey(serial string) string {
+	serial = strings.ToLower(strings.ReplaceAll(serial, ":", ""))
+	serial = strings.TrimLeft(serial, "0")
+	if serial == "" {
+		return "0"
+	}
+	return serial
+}
+
+// sanitizeIdentity превращает идентификатор камеры в безопасное имя файла и директории
+func sanitizeIdentity(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+		if b.Len() >= maxIdentityLength {
+			break
+		}
+	}
+	return strings.TrimLeft(b.String(), ".")
+}
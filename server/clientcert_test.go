@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientCertDenyList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	list := "# запреты\nserial:0B:AD\ncn:cafe\n\n"
+	if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &ClientCertPolicy{revoked: map[string]bool{}, deniedSerial: map[string]bool{}, deniedCN: map[string]bool{}}
+	if err := p.loadDenyList(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cn     string
+		serial int64
+		denied bool
+	}{
+		{"запрещенный серийный номер", "camera-1", 0xbad, true},
+		{"запрещенный CN", "cafe", 1, true},
+		// CN из списка не запрещает одноименный серийный номер, и наоборот
+		{"серийный номер как запрещенный CN", "camera-2", 0xcafe, false},
+		{"CN как запрещенный серийный номер", "bad", 2, false},
+		{"разрешенный", "camera-3", 3, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		cert := &x509.Certificate{SerialNumber: big.NewInt(tt.serial), Subject: pkix.Name{CommonName: tt.cn}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		identity, err := p.Identify(r)
+		if tt.denied {
+			if !errors.Is(err, errRevokedClientCert) {
+				t.Errorf("%s: ошибка %v, ожидалась %v", tt.name, err, errRevokedClientCert)
+			}
+			continue
+		}
+		if err != nil || identity != tt.cn {
+			t.Errorf("%s: Identify = %q, %v", tt.name, identity, err)
+		}
+	}
+}
+
+func TestClientCertDenyListErrors(t *testing.T) {
+	tests := map[string]string{
+		"без вида записи": "camera-1\n",
+		"неизвестный вид": "name:camera-1\n",
+		"пустое значение": "cn:\n",
+		"серийный номер":  "serial:xyz\n",
+	}
+	for name, list := range tests {
+		path := filepath.Join(t.TempDir(), "deny.txt")
+		if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p := &ClientCertPolicy{deniedSerial: map[string]bool{}, deniedCN: map[string]bool{}}
+		if err := p.loadDenyList(path); err == nil || !strings.Contains(err.Error(), ":1: ") {
+			t.Errorf("%s: ошибка %v, ожидалась с номером строки", name, err)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEnvPrefix — префикс переменных окружения, переопределяющих настройки:
+// флаг -retention-max-age соответствует переменной WEBCAM_RETENTION_MAX_AGE
+const configEnvPrefix = "WEBCAM_"
+
+// commandOnlySettings задаются только в командной строке: они не относятся к работе сервера
+var commandOnlySettings = map[string]bool{
+	"config":        true,
+	"issue-token":   true,
+	"token-subject": true,
+	"token-admin":   true,
+}
+
+// reloadableSettings можно изменить по SIGHUP без перезапуска сервера
+var reloadableSettings = map[string]bool{
+	"auth-key-file":           true,
+	"auth-tokens-file":        true,
+	"auth-admin-tokens-file":  true,
+	"tls-require-client-cert": true,
+	"tls-crl":                 true,
+	"tls-deny-list":           true,
+	"stream-conflict":         true,
+	"max-sessions":            true,
+	"max-sessions-per-ip":     true,
+	"max-message-size":        true,
+	"max-bitrate":             true,
+	"ready-min-free-bytes":    true,
+	"retention-max-age":       true,
+	"retention-max-bytes":     true,
+	"retention-interval":      true,
+	"retention-dry-run":       true,
+}
+
+// Config содержит настройки сервера. Источники в порядке возрастания приоритета:
+// значения по умолчанию, файл конфигурации, переменные окружения, флаги командной строки.
+type Config struct {
+	ConfigFile   string
+	IssueToken   time.Duration
+	TokenSubject string
+	TokenAdmin   bool
+
+	Port                int
+	OutputDir           string
+	Format              string
+	SegmentDuration     time.Duration
+	SegmentSize         int64
+	HLSEnabled          bool
+	HLSSegmentDuration  time.Duration
+	HLSWindow           int
+	RetentionMaxAge     time.Duration
+	RetentionMaxBytes   int64
+	RetentionInterval   time.Duration
+	RetentionDryRun     bool
+	AuthKeyFile         string
+	AuthTokensFile      string
+	AuthAdminTokensFile string
+	TLSCert             string
+	TLSKey              string
+	TLSSelfSigned       bool
+	TLSClientCA         string
+	TLSRequireCert      bool
+	TLSCRL              string
+	TLSDenyList         string
+	StreamConflict      string
+	ResumeGrace         time.Duration
+	ShutdownTimeout     time.Duration
+	MaxSessions         int
+	MaxSessionsPerIP    int
+	MaxMessageSize      int64
+	MaxBitrate          int64
+	ReadyMinFreeBytes   uint64
+	ViewerQueue         int
+
+	values map[string]string // итоговые значения всех настроек для сравнения при перезагрузке
+}
+
+// newFlagSet описывает все настройки сервера в виде флагов
+func newFlagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&c.ConfigFile, "config", "", "файл конфигурации в формате ключ = значение (также WEBCAM_CONFIG)")
+	fs.IntVar(&c.Port, "port", 8080, "порт для запуска сервера")
+	fs.StringVar(&c.OutputDir, "output", "recordings", "директория для сохранения записей")
+	fs.StringVar(&c.Format, "format", formatH264, "формат записи: h264 (сырой Annex-B) или mp4 (фрагментированный MP4)")
+	fs.DurationVar(&c.SegmentDuration, "segment-duration", 0, "максимальная длительность сегмента записи, например 1h (0 — без нарезки)")
+	fs.Int64Var(&c.SegmentSize, "segment-size", 0, "максимальный размер сегмента записи в байтах (0 — без нарезки)")
+	fs.BoolVar(&c.HLSEnabled, "hls", true, "формировать живой HLS-плейлист для каждого потока")
+	fs.DurationVar(&c.HLSSegmentDuration, "hls-segment-duration", 2*time.Second, "желаемая длительность живого HLS-сегмента")
+	fs.IntVar(&c.HLSWindow, "hls-window", 6, "количество сегментов в живом HLS-плейлисте")
+	fs.DurationVar(&c.RetentionMaxAge, "retention-max-age", 0, "удалять законченные записи старше указанного возраста, например 720h (0 — без ограничения)")
+	fs.Int64Var(&c.RetentionMaxBytes, "retention-max-bytes", 0, "максимальный общий объем записей в байтах (0 — без ограничения)")
+	fs.DurationVar(&c.RetentionInterval, "retention-interval", time.Minute, "период проверки политики хранения")
+	fs.BoolVar(&c.RetentionDryRun, "retention-dry-run", false, "только сообщать в журнал, какие записи были бы удалены")
+	fs.StringVar(&c.AuthKeyFile, "auth-key-file", "", "файл с ключом подписи HMAC для токенов клиентов")
+	fs.StringVar(&c.AuthTokensFile, "auth-tokens-file", "", "файл со статическими токенами клиентов, по одному в строке")
+	fs.StringVar(&c.AuthAdminTokensFile, "auth-admin-tokens-file", "", "файл со статическими токенами администраторов для API управления, по одному в строке")
+	fs.DurationVar(&c.IssueToken, "issue-token", 0, "выпустить подписанный токен с указанным сроком действия и выйти")
+	fs.StringVar(&c.TokenSubject, "token-subject", "", "субъект (имя камеры) для выпускаемого токена")
+	fs.BoolVar(&c.TokenAdmin, "token-admin", false, "выпустить токен администратора для API управления вместо токена камеры")
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "файл сертификата сервера (PEM) для HTTPS/WSS")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "файл закрытого ключа сервера (PEM) для HTTPS/WSS")
+	fs.BoolVar(&c.TLSSelfSigned, "tls-self-signed", false, "создать самоподписанный сертификат при первом запуске, если файлов еще нет")
+	fs.StringVar(&c.TLSClientCA, "tls-client-ca", "", "файл CA (PEM) для проверки клиентских сертификатов камер")
+	fs.BoolVar(&c.TLSRequireCert, "tls-require-client-cert", false, "не принимать камеры без клиентского сертификата")
+	fs.StringVar(&c.TLSCRL, "tls-crl", "", "файл CRL (PEM или DER) с отозванными клиентскими сертификатами")
+	fs.StringVar(&c.TLSDenyList, "tls-deny-list", "", "файл запретов клиентских сертификатов: по одной записи serial:<hex> или cn:<имя> в строке")
+	fs.StringVar(&c.StreamConflict, "stream-conflict", streamConflictReject, "что делать при повторной публикации активного потока: reject (отклонить нового издателя) или takeover (отключить прежнего)")
+	fs.DurationVar(&c.ResumeGrace, "resume-grace", 30*time.Second, "сколько сессия ждет переподключения камеры после обрыва соединения, продолжая ту же запись (0 — не ждать)")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "сколько ждать завершения записей при остановке сервера")
+	fs.IntVar(&c.MaxSessions, "max-sessions", 0, "предел одновременных потоков камер (0 — без ограничения)")
+	fs.IntVar(&c.MaxSessionsPerIP, "max-sessions-per-ip", 0, "предел одновременных потоков с одного IP-адреса (0 — без ограничения)")
+	fs.Int64Var(&c.MaxMessageSize, "max-message-size", 16<<20, "максимальный размер сообщения WebSocket от камеры в байтах (0 — без ограничения)")
+	fs.Int64Var(&c.MaxBitrate, "max-bitrate", 0, "предельная скорость потока одной камеры в бит/с (0 — без ограничения)")
+	fs.Uint64Var(&c.ReadyMinFreeBytes, "ready-min-free-bytes", 256<<20, "минимальный запас свободного места в директории записей для готовности /readyz")
+	fs.IntVar(&c.ViewerQueue, "viewer-queue", 60, "размер очереди кадров каждого зрителя /watch")
+	return fs
+}
+
+// LoadConfig собирает настройки из аргументов командной строки, файла конфигурации
+// и переменных окружения и проверяет их
+func LoadConfig(args []string) (*Config, error) {
+	c := &Config{}
+	fs := newFlagSet(c)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Флаги командной строки имеют наивысший приоритет
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if c.ConfigFile == "" {
+		c.ConfigFile = os.Getenv(configEnvPrefix + "CONFIG")
+	}
+	if c.ConfigFile != "" {
+		settings, err := readConfigFile(c.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, setting := range settings {
+			if fs.Lookup(setting.Name) == nil || commandOnlySettings[setting.Name] {
+				return nil, fmt.Errorf("%s:%d: неизвестный параметр %s", c.ConfigFile, setting.Line, setting.Key)
+			}
+			if explicit[setting.Name] {
+				continue
+			}
+			if err := fs.Set(setting.Name, setting.Value); err != nil {
+				return nil, fmt.Errorf("%s:%d: параметр %s: %v", c.ConfigFile, setting.Line, setting.Key, err)
+			}
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if commandOnlySettings[f.Name] || explicit[f.Name] || envErr != nil {
+			return
+		}
+		env := configEnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("переменная %s: %v", env, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	c.values = make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		c.values[f.Name] = f.Value.String()
+	})
+	return c, c.validate()
+}
+
+// validate проверяет согласованность настроек
+func (c *Config) validate() error {
+	switch {
+	case c.Port <= 0 || c.Port > 65535:
+		return fmt.Errorf("некорректный порт: %d", c.Port)
+	case c.Format != formatH264 && c.Format != formatMP4:
+		return fmt.Errorf("неизвестный формат записи: %s", c.Format)
+	case c.StreamConflict != streamConflictReject && c.StreamConflict != streamConflictTakeover:
+		return fmt.Errorf("неизвестная политика stream-conflict: %s", c.StreamConflict)
+	case c.HLSEnabled && (c.HLSSegmentDuration <= 0 || c.HLSWindow <= 0):
+		return fmt.Errorf("длительность HLS-сегмента и размер окна должны быть положительными")
+	case c.RetentionInterval <= 0:
+		return fmt.Errorf("период проверки политики хранения должен быть положительным")
+	case c.ResumeGrace < 0:
+		return fmt.Errorf("время ожидания возобновления сессии не может быть отрицательным")
+	case c.ViewerQueue <= 0:
+		return fmt.Errorf("размер очереди зрителя должен быть положительным")
+	case c.MaxSessions < 0 || c.MaxSessionsPerIP < 0 || c.MaxMessageSize < 0 || c.MaxBitrate < 0:
+		return fmt.Errorf("ограничения для камер не могут быть отрицательными")
+	case c.TLSClientCA != "" && !c.tlsOptions().enabled():
+		return fmt.Errorf("клиентские сертификаты требуют включенного TLS")
+	case c.TLSRequireCert && c.TLSClientCA == "":
+		return fmt.Errorf("для обязательных клиентских сертификатов нужен tls-client-ca")
+	}
+	return nil
+}
+
+// changes возвращает настройки, значения которых в next отличаются от текущих
+func (c *Config) changes(next *Config) []string {
+	var changed []string
+	for name, value := range next.values {
+		if !commandOnlySettings[name] && c.values[name] != value {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// applyReloadable переносит из next значения настроек, перечисленных в reloadableSettings
+func (c *Config) applyReloadable(next *Config) {
+	c.AuthKeyFile = next.AuthKeyFile
+	c.AuthTokensFile = next.AuthTokensFile
+	c.AuthAdminTokensFile = next.AuthAdminTokensFile
+	c.TLSRequireCert = next.TLSRequireCert
+	c.TLSCRL = next.TLSCRL
+	c.TLSDenyList = next.TLSDenyList
+	c.StreamConflict = next.StreamConflict
+	c.MaxSessions = next.MaxSessions
+	c.MaxSessionsPerIP = next.MaxSessionsPerIP
+	c.MaxMessageSize = next.MaxMessageSize
+	c.MaxBitrate = next.MaxBitrate
+	c.ReadyMinFreeBytes = next.ReadyMinFreeBytes
+	c.RetentionMaxAge = next.RetentionMaxAge
+	c.RetentionMaxBytes = next.RetentionMaxBytes
+	c.RetentionInterval = next.RetentionInterval
+	c.RetentionDryRun = next.RetentionDryRun
+}
+
+// ingestLimits возвращает ограничения для камер
+func (c *Config) ingestLimits() IngestLimits {
+	return IngestLimits{
+		MaxSessions:      c.MaxSessions,
+		MaxSessionsPerIP: c.MaxSessionsPerIP,
+		MaxMessageSize:   c.MaxMessageSize,
+		MaxBitrate:       c.MaxBitrate,
+	}
+}
+
+// writerOptions возвращает параметры записи
+func (c *Config) writerOptions() WriterOptions {
+	return WriterOptions{
+		OutputDir:       c.OutputDir,
+		Format:          c.Format,
+		SegmentDuration: c.SegmentDuration,
+		SegmentSize:     c.SegmentSize,
+	}
+}
+
+// hlsOptions возвращает параметры живого HLS
+func (c *Config) hlsOptions() HLSOptions {
+	return HLSOptions{
+		Enabled:         c.HLSEnabled,
+		SegmentDuration: c.HLSSegmentDuration,
+		Window:          c.HLSWindow,
+	}
+}
+
+// retentionOptions возвращает политику хранения
+func (c *Config) retentionOptions() RetentionOptions {
+	return RetentionOptions{
+		MaxAge:   c.RetentionMaxAge,
+		MaxBytes: c.RetentionMaxBytes,
+		Interval: c.RetentionInterval,
+		DryRun:   c.RetentionDryRun,
+	}
+}
+
+// tlsOptions возвращает параметры TLS
+func (c *Config) tlsOptions() TLSOptions {
+	return TLSOptions{
+		CertFile:          c.TLSCert,
+		KeyFile:           c.TLSKey,
+		SelfSigned:        c.TLSSelfSigned,
+		ClientCAFile:      c.TLSClientCA,
+		RequireClientCert: c.TLSRequireCert,
+		CRLFile:           c.TLSCRL,
+		DenyListFile:      c.TLSDenyList,
+	}
+}
+
+// configSetting — параметр из файла конфигурации
+type configSetting struct {
+	Key   string // ключ с разделом, например retention.max_age
+	Name  string // соответствующий флаг, например retention-max-age
+	Value string
+	Line  int
+}
+
+// readConfigFile читает файл конфигурации. Это собственный построчный формат, внешне похожий на TOML,
+// но не TOML: его грамматика ограничена тем, что описано ниже, и файл разбирается строго по ней.
+//
+//   - Пустые строки пропускаются; # вне кавычек начинает комментарий до конца строки.
+//   - Строка [имя] начинает раздел: до следующего раздела к ключам добавляется префикс "имя.".
+//     Пробелы внутри скобок отбрасываются, вложенных разделов и [[массивов таблиц]] нет.
+//   - Остальные строки имеют вид ключ = значение; ключ — все до первого "=" без пробелов по краям.
+//   - Значение в двойных кавычках разбирается по правилам строк Go (strconv.Unquote),
+//     в одинарных — берется как есть, без экранирования.
+//   - Значение без кавычек берется как есть, из него удаляются "_" (разделители разрядов: 1_000).
+//   - Массивы, встроенные таблицы и многострочные строки не поддерживаются.
+//
+// Ключ retention.max_age соответствует флагу -retention-max-age.
+func readConfigFile(path string) ([]configSetting, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл конфигурации: %v", err)
+	}
+
+	var settings []configSetting
+	seen := make(map[string]bool)
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, fmt.Errorf("%s:%d: некорректный заголовок раздела", path, line)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: ожидается ключ = значение", path, line)
+		}
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+
+		name := strings.NewReplacer(".", "-", "_", "-").Replace(key)
+		if seen[name] {
+			return nil, fmt.Errorf("%s:%d: параметр %s задан повторно", path, line, key)
+		}
+		seen[name] = true
+		settings = append(settings, configSetting{Key: key, Name: name, Value: value, Line: line})
+	}
+	return settings, scanner.Err()
+}
+
+// parseConfigValue разбирает значение параметра: строку в кавычках или значение без кавычек
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("пустое значение")
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("незакрытая строка: %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "[") || strings.HasPrefix(raw, "{"):
+		return "", fmt.Errorf("массивы и таблицы в строке не поддерживаются")
+	}
+	// Числа могут содержать разделители разрядов: 1_000_000
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// stripComment удаляет комментарий, начинающийся с # вне кавычек
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // экранированный символ не закрывает строку
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig сохраняет текст файла конфигурации во временную директорию теста
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.conf")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStripComment(t *testing.T) {
+	tests := map[string]string{
+		`port = 8080`:                       `port = 8080`,
+		`port = 8080 # порт`:                `port = 8080 `,
+		`# только комментарий`:              ``,
+		`output = "rec#1" # директория`:     `output = "rec#1" `,
+		`output = 'rec#1' # директория`:     `output = 'rec#1' `,
+		`output = "a\"#b" # кавычка`:        `output = "a\"#b" `,
+		`output = "a\\" # обратная черта`:   `output = "a\\" `,
+		`output = 'a\' # без экранирования`: `output = 'a\' `,
+		`output = "it's" # апостроф`:        `output = "it's" `,
+	}
+	for line, want := range tests {
+		if got := stripComment(line); got != want {
+			t.Errorf("stripComment(%q) = %q, ожидалось %q", line, got, want)
+		}
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  bool
+	}{
+		{`"recordings"`, "recordings", false},
+		{`"C:\\rec\ttab"`, "C:\\rec\ttab", false},
+		{`"кавычка \" внутри"`, `кавычка " внутри`, false},
+		{`'C:\rec'`, `C:\rec`, false},
+		{`''`, "", false},
+		{`8080`, "8080", false},
+		{`16_777_216`, "16777216", false},
+		{`1_000`, "1000", false},
+		{`true`, "true", false},
+		{`"30s"`, "30s", false},
+		{``, "", true},
+		{`"незакрытая`, "", true},
+		{`'незакрытая`, "", true},
+		{`'`, "", true},
+		{`[1, 2]`, "", true},
+		{`{a = 1}`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseConfigValue(tt.raw)
+		if (err != nil) != tt.err {
+			t.Errorf("parseConfigValue(%q): ошибка %v, ожидалась ошибка: %v", tt.raw, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseConfigValue(%q) = %q, ожидалось %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+# комментарий
+port = 9000
+output = "rec # не комментарий" # комментарий
+segment_size = 1_000
+
+[retention]
+max_age = "720h"
+dry_run = true
+
+[ auth ]
+key_file = 'keys\hmac.key'
+`)
+	settings, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []configSetting{
+		{Key: "port", Name: "port", Value: "9000", Line: 3},
+		{Key: "output", Name: "output", Value: "rec # не комментарий", Line: 4},
+		{Key: "segment_size", Name: "segment-size", Value: "1000", Line: 5},
+		{Key: "retention.max_age", Name: "retention-max-age", Value: "720h", Line: 8},
+		{Key: "retention.dry_run", Name: "retention-dry-run", Value: "true", Line: 9},
+		{Key: "auth.key_file", Name: "auth-key-file", Value: `keys\hmac.key`, Line: 12},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("получено %+v,\nожидалось %+v", settings, want)
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := map[string]struct {
+		text string
+		want string
+	}{
+		"повтор ключа":            {"port = 1\nport = 2\n", ":2: параметр port задан повторно"},
+		"повтор через раздел":     {"retention_max_age = \"1h\"\n[retention]\nmax_age = \"2h\"\n", ":3: параметр retention.max_age задан повторно"},
+		"без значения":            {"port\n", ":1: ожидается ключ = значение"},
+		"пустое значение":         {"port =\n", ":1: пустое значение"},
+		"незакрытый раздел":       {"[retention\n", ":1: некорректный заголовок раздела"},
+		"массив таблиц":           {"[[retention]]\n", ":1: некорректный заголовок раздела"},
+		"массив":                  {"port = [1]\n", ":1: массивы и таблицы в строке не поддерживаются"},
+		"встроенная таблица":      {"retention = {max_age = \"1h\"}\n", ":1: массивы и таблицы в строке не поддерживаются"},
+		"многострочная строка":    {"output = \"\"\"rec\n\"\"\"\n", ":1: "},
+		"испорченная строка":      {"output = \"a\\q\"\n", ":1: "},
+		"незакрытая одинарная":    {"output = 'rec\n", ":1: незакрытая строка"},
+		"комментарий после ключа": {"port # = 1\n", ":1: ожидается ключ = значение"},
+	}
+	for name, tt := range tests {
+		_, err := readConfigFile(writeConfig(t, tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ошибка %v, ожидалась содержащая %q", name, err, tt.want)
+		}
+	}
+
+	if _, err := readConfigFile(filepath.Join(t.TempDir(), "missing.conf")); err == nil {
+		t.Error("отсутствующий файл: ожидалась ошибка")
+	}
+}
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	tests := map[string]string{
+		"неизвестный параметр":    "no_such_setting = 1\n",
+		"неизвестный раздел":      "[nosuch]\nport = 1\n",
+		"только командная строка": "issue_token = \"1h\"\n",
+		"файл конфигурации":       "config = \"other.conf\"\n",
+	}
+	for name, text := range tests {
+		_, err := LoadConfig([]string{"-config", writeConfig(t, text)})
+		if err == nil || !strings.Contains(err.Error(), "неизвестный параметр") {
+			t.Errorf("%s: ошибка %v, ожидалась ошибка о неизвестном параметре", name, err)
+		}
+	}
+
+	_, err := LoadConfig([]string{"-config", writeConfig(t, "port = \"восемь\"\n")})
+	if err == nil || !strings.Contains(err.Error(), ":1: параметр port") {
+		t.Errorf("некорректное значение: ошибка %v", err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+port = 9001
+output = "from-file"
+format = "mp4"
+
+[retention]
+max_age = "1h"
+`)
+	t.Setenv("WEBCAM_PORT", "9002")
+	t.Setenv("WEBCAM_RETENTION_MAX_AGE", "2h")
+
+	c, err := LoadConfig([]string{"-config", path, "-port", "9003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// флаг > окружение > файл > значение по умолчанию
+	if c.Port != 9003 {
+		t.Errorf("Port = %d, ожидалось значение флага 9003", c.Port)
+	}
+	if c.RetentionMaxAge != 2*time.Hour {
+		t.Errorf("RetentionMaxAge = %v, ожидалось значение окружения 2h", c.RetentionMaxAge)
+	}
+	if c.OutputDir != "from-file" || c.Format != formatMP4 {
+		t.Errorf("OutputDir = %q, Format = %q, ожидались значения из файла", c.OutputDir, c.Format)
+	}
+	if c.HLSWindow != 6 {
+		t.Errorf("HLSWindow = %d, ожидалось значение по умолчанию 6", c.HLSWindow)
+	}
+	if c.values["port"] != "9003" || c.values["retention-max-age"] != "2h0m0s" {
+		t.Errorf("итоговые значения: port = %q, retention-max-age = %q", c.values["port"], c.values["retention-max-age"])
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	t.Setenv("WEBCAM_CONFIG", writeConfig(t, "port = 9004\n"))
+	c, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 9004 {
+		t.Errorf("Port = %d, ожидалось значение из файла, указанного в WEBCAM_CONFIG", c.Port)
+	}
+
+	// Файл из флага важнее файла из окружения
+	c, err = LoadConfig([]string{"-config", writeConfig(t, "port = 9005\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 9005 {
+		t.Errorf("Port = %d, ожидалось значение из файла, указанного флагом", c.Port)
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	if _, err := LoadConfig([]string{"-config", "config.example.conf"}); err != nil {
+		t.Errorf("пример файла конфигурации: %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// controlProtocolVersion — версия протокола управления, которую поддерживает сервер
+const controlProtocolVersion = 1
+
+// helloTimeout — сколько ждать первого сообщения клиента после подключения
+const helloTimeout = 10 * time.Second
+
+// controlWriteTimeout ограничивает отправку одного управляющего сообщения
+const controlWriteTimeout = 2 * time.Second
+
+// Типы управляющих сообщений (текстовые сообщения WebSocket в JSON)
+const (
+	messageHello  = "hello"  // клиент → сервер: параметры клиента и потока
+	messageAccept = "accept" // сервер → клиент: сессия принята
+	messageError  = "error"  // сервер → клиент: ошибка
+	messageAck    = "ack"    // сервер → клиент: номер последнего полученного кадра
+
+	// Команды камере (сервер → клиент)
+	messageForceIDR   = "force_idr"   // немедленно закодировать ключевой кадр
+	messageSetBitrate = "set_bitrate" // изменить целевой битрейт кодировщика
+	messagePause      = "pause"       // приостановить передачу видео
+	messageResume     = "resume"      // возобновить передачу видео с ключевого кадра
+)
+
+// ackInterval — как часто сервер подтверждает клиенту полученные кадры
+const ackInterval = time.Second
+
+// keyframeRequestInterval — наименьший интервал между автоматическими запросами
+// ключевого кадра у одной камеры
+const keyframeRequestInterval = time.Second
+
+// errControlUnsupported — клиент подключен без протокола управления и не принимает команды
+var errControlUnsupported = errors.New("клиент не поддерживает протокол управления")
+
+// Коды ошибок в сообщениях error
+const (
+	errorBadRequest         = "bad_request"
+	errorUnsupportedVersion = "unsupported_version"
+	errorUnsupportedCodec   = "unsupported_codec"
+	errorStreamActive       = "stream_active"
+	errorStreamTakenOver    = "stream_taken_over"
+	errorShuttingDown       = "shutting_down"
+	errorInvalidStream      = "invalid_stream"
+	errorPolicyViolation    = "policy_violation"
+	errorClosed             = "closed"
+)
+
+// controlMessage — общая часть всех управляющих сообщений
+type controlMessage struct {
+	Type string `json:"type"`
+}
+
+// helloMessage — первое сообщение клиента, поддерживающего протокол управления
+type helloMessage struct {
+	Type     string `json:"type"`
+	Version  int    `json:"version"`
+	ClientID string `json:"client_id,omitempty"`
+	Codec    string `json:"codec"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	FPS      int    `json:"fps,omitempty"`
+	Bitrate  int    `json:"bitrate,omitempty"`
+	Software string `json:"software,omitempty"`
+	Framed   bool   `json:"framed,omitempty"` // клиент умеет передавать кадры с заголовком (frame.go)
+
+	Resumable bool           `json:"resumable,omitempty"` // клиент умеет возобновлять сессию после обрыва
+	Resume    *resumeRequest `json:"resume,omitempty"`    // клиент продолжает прерванную сессию
+}
+
+// resumeRequest — сессия, которую клиент хочет продолжить после переподключения
+type resumeRequest struct {
+	SessionID    string `json:"session_id"`
+	Key          string `json:"key"`           // ключ возобновления из accept.resume_key
+	LastSequence uint32 `json:"last_sequence"` // последний кадр, подтвержденный сервером
+}
+
+// acceptMessage — ответ сервера на hello
+type acceptMessage struct {
+	Type      string        `json:"type"`
+	Version   int           `json:"version"`
+	SessionID string        `json:"session_id"`
+	Framed    bool          `json:"framed,omitempty"` // сервер ждет кадры с заголовком, иначе — сырой Annex-B
+	Limits    *acceptLimits `json:"limits,omitempty"`
+
+	Resumed      bool    `json:"resumed,omitempty"`       // продолжена сессия из hello.resume
+	LastSequence *uint32 `json:"last_sequence,omitempty"` // последний кадр, полученный до обрыва
+	ResumeGrace  int     `json:"resume_grace,omitempty"`  // сколько секунд сессия ждет возобновления
+	ResumeKey    string  `json:"resume_key,omitempty"`    // секрет для hello.resume, известный только клиенту
+}
+
+// acceptLimits — ограничения сервера, которые клиент должен соблюдать
+type acceptLimits struct {
+	MaxMessageSize int64 `json:"max_message_size,omitempty"`
+	MaxBitrate     int64 `json:"max_bitrate,omitempty"`
+}
+
+// ackMessage сообщает клиенту номер последнего кадра, полученного сервером по порядку
+type ackMessage struct {
+	Type     string `json:"type"`
+	Sequence uint32 `json:"sequence"`
+}
+
+// errorMessage — типизированная ошибка, которую сервер отправляет клиенту
+type errorMessage struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// commandMessage — команда, которую сервер отправляет камере
+type commandMessage struct {
+	Type    string `json:"type"`
+	Bitrate int64  `json:"bitrate,omitempty"` // бит/с, только для set_bitrate
+}
+
+// validate проверяет тип и параметры команды
+func (m commandMessage) validate() error {
+	switch m.Type {
+	case messageForceIDR, messagePause, messageResume:
+		return nil
+	case messageSetBitrate:
+		if m.Bitrate <= 0 {
+			return fmt.Errorf("битрейт должен быть положительным")
+		}
+		return nil
+	}
+	return fmt.Errorf("неизвестная команда %q", m.Type)
+}
+
+// helloError — ошибка в сообщении hello
+type helloError struct {
+	code    string
+	message string
+}
+
+func (e *helloError) Error() string {
+	return e.message
+}
+
+// parseHello разбирает и проверяет сообщение hello
+func parseHello(data []byte) (*helloMessage, error) {
+	hello := &helloMessage{}
+	if err := json.Unmarshal(data, hello); err != nil {
+		return nil, &helloError{errorBadRequest, fmt.Sprintf("некорректное сообщение: %v", err)}
+	}
+	if hello.Type != messageHello {
+		return nil, &helloError{errorBadRequest, fmt.Sprintf("ожидалось сообщение hello, получено %q", hello.Type)}
+	}
+	if hello.Version < 1 {
+		return nil, &helloError{errorUnsupportedVersion, fmt.Sprintf("неподдерживаемая версия протокола %d", hello.Version)}
+	}
+	if !strings.EqualFold(hello.Codec, "h264") {
+		return nil, &helloError{errorUnsupportedCodec, fmt.Sprintf("неподдерживаемый кодек %q, сервер принимает только h264", hello.Codec)}
+	}
+	return hello, nil
+}
+
+// videoConfig возвращает параметры видео из hello для метаданных записи
+func (m *helloMessage) videoConfig() *clientVideoConfig {
+	return &clientVideoConfig{
+		ClientID:  m.ClientID,
+		Width:     m.Width,
+		Height:    m.Height,
+		FrameRate: m.FPS,
+		BitRate:   m.Bitrate,
+		Codec:     strings.ToLower(m.Codec),
+		Software:  m.Software,
+	}
+}
+
+// controlChannel отправляет клиенту управляющие сообщения. Сообщения могут
+// отправляться из разных горутин, поэтому запись защищена мьютексом.
+// Клиенты без протокола управления (legacy) сообщений не получают.
+type controlChannel struct {
+	mutex   sync.Mutex
+	conn    *websocket.Conn
+	enabled bool
+}
+
+// send отправляет управляющее сообщение, если клиент поддерживает протокол
+func (c *controlChannel) send(message any) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.enabled {
+		return nil
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// sendError отправляет клиенту типизированную ошибку
+func (c *controlChannel) sendError(code, message string) error {
+	return c.send(errorMessage{Type: messageError, Code: code, Message: message})
+}
+
+// cameraControl отправляет команды камере сессии и помнит ее состояние для API.
+// У клиентов без протокола управления канала нет, и команды им не отправляются.
+type cameraControl struct {
+	mutex        sync.Mutex
+	sessionID    string
+	channel      *controlChannel
+	paused       bool
+	bitrate      int64 // битрейт из последней команды set_bitrate
+	lastKeyframe time.Time
+}
+
+// cameraState — состояние камеры, управляемой командами
+type cameraState struct {
+	Paused  bool  `json:"paused"`
+	Bitrate int64 `json:"bitrate,omitempty"`
+}
+
+// attach задает канал, через который камера принимает команды.
+// Новое подключение камеры начинает передачу без паузы.
+func (c *cameraControl) attach(sessionID string, channel *controlChannel) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sessionID = sessionID
+	c.channel = channel
+	c.paused = false
+}
+
+// state возвращает состояние камеры или nil, если она не принимает команды
+func (c *cameraControl) state() *cameraState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.channel == nil {
+		return nil
+	}
+	return &cameraState{Paused: c.paused, Bitrate: c.bitrate}
+}
+
+// send проверяет и отправляет команду камере
+func (c *cameraControl) send(command commandMessage) error {
+	if err := command.validate(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	channel := c.channel
+	c.mutex.Unlock()
+	if channel == nil {
+		return errControlUnsupported
+	}
+
+	// Отправка может занять до controlWriteTimeout, поэтому идет без блокировки:
+	// запросы ключевого кадра из обработки кадров не должны ее ждать
+	if err := channel.send(command); err != nil {
+		return fmt.Errorf("не удалось отправить команду: %v", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch command.Type {
+	case messageForceIDR:
+		c.lastKeyframe = time.Now()
+	case messageSetBitrate:
+		c.bitrate = command.Bitrate
+	case messagePause:
+		c.paused = true
+	case messageResume:
+		c.paused = false
+	}
+	return nil
+}
+
+// requestKeyframe просит камеру закодировать ключевой кадр, не чаще keyframeRequestInterval.
+// Команда отправляется в отдельной горутине, чтобы не задерживать обработку кадров.
+func (c *cameraControl) requestKeyframe(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.channel == nil || c.paused || time.Since(c.lastKeyframe) < keyframeRequestInterval {
+		return
+	}
+	c.lastKeyframe = time.Now()
+
+	channel, sessionID := c.channel, c.sessionID
+	go func() {
+		if err := channel.send(commandMessage{Type: messageForceIDR}); err != nil {
+			log.Printf("Не удалось запросить ключевой кадр у сессии %s: %v", sessionID, err)
+			return
+		}
+		log.Printf("Запрошен ключевой кадр у сессии %s: %s", sessionID, reason)
+	}()
+}
+
+// closeErrorCode возвращает код ошибки протокола для кода закрытия WebSocket
+func closeErrorCode(closeCode int) string {
+	switch closeCode {
+	case closeStreamTakenOver:
+		return errorStreamTakenOver
+	case websocket.CloseGoingAway:
+		return errorShuttingDown
+	case websocket.CloseUnsupportedData:
+		return errorInvalidStream
+	case websocket.ClosePolicyViolation:
+		return errorPolicyViolation
+	}
+	return errorClosed
+}
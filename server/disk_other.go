@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// diskFree на этой платформе не поддерживается
+func diskFree(dir string) (uint64, error) {
+	return 0, errors.New("определение свободного места не поддерживается")
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// fmp4Timescale — единица времени дорожки (90 кГц, как в RTP и MPEG-TS)
+const fmp4Timescale = 90000
+
+// fmp4DefaultDuration используется для последнего кадра, когда следующего уже не будет
+const fmp4DefaultDuration = fmp4Timescale / 30
+
+// Флаги сэмплов в trun
+const (
+	sampleFlagsKeyframe    = 0x02000000
+	sampleFlagsNonKeyframe = 0x01010000
+)
+
+// fmp4Sample — один кадр внутри фрагмента
+type fmp4Sample struct {
+	data     []byte
+	pts      time.Duration
+	duration uint32
+	keyframe bool
+}
+
+// fmp4Writer перепаковывает кадры H.264 во фрагментированный MP4.
+// Каждая группа кадров (GOP) записывается отдельным фрагментом moof+mdat,
+// поэтому файл остается воспроизводимым, даже если клиент отключится посреди потока.
+type fmp4Writer struct {
+	w          io.Writer
+	sps        []byte
+	pps        []byte
+	initDone   bool
+	sequence   uint32
+	decodeTime uint64
+	samples    []fmp4Sample
+
+	// fragmentPerFrame включает отдельный фрагмент на каждый кадр, что снижает задержку живого просмотра
+	fragmentPerFrame bool
+}
+
+// newFMP4Writer создает новый fmp4Writer.
+// Ранее полученные SPS и PPS позволяют начать файл с ключевого кадра без параметров.
+func newFMP4Writer(w io.Writer, sps, pps []byte) *fmp4Writer {
+	return &fmp4Writer{w: w, sps: sps, pps: pps}
+}
+
+// WriteAccessUnit добавляет кадр; завершенная группа кадров сразу сбрасывается в w
+func (m *fmp4Writer) WriteAccessUnit(au *h264.AccessUnit) error {
+	for _, nalu := range au.NALUs {
+		switch h264.Type(nalu) {
+		case h264.NALTypeSPS:
+			m.sps = nalu
+		case h264.NALTypePPS:
+			m.pps = nalu
+		}
+	}
+
+	if !m.initDone {
+		// Без параметров и ключевого кадра декодер не сможет начать воспроизведение
+		if !au.Keyframe || m.sps == nil || m.pps == nil {
+			return nil
+		}
+		init, err := buildInitSegment(m.sps, m.pps)
+		if err != nil {
+			return err
+		}
+		if _, err := m.w.Write(init); err != nil {
+			return err
+		}
+		m.initDone = true
+	}
+
+	if n := len(m.samples); n > 0 {
+		m.samples[n-1].duration = ptsToTicks(au.PTS - m.samples[n-1].pts)
+		if au.Keyframe || m.fragmentPerFrame {
+			if err := m.flushFragment(); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.samples = append(m.samples, fmp4Sample{
+		data:     annexBToAVCC(au.NALUs),
+		pts:      au.PTS,
+		keyframe: au.Keyframe,
+	})
+	return nil
+}
+
+// Flush записывает незавершенную группу кадров
+func (m *fmp4Writer) Flush() error {
+	n := len(m.samples)
+	if n == 0 {
+		return nil
+	}
+	m.samples[n-1].duration = fmp4DefaultDuration
+	if n > 1 {
+		m.samples[n-1].duration = m.samples[n-2].duration
+	}
+	return m.flushFragment()
+}
+
+// flushFragment записывает накопленные кадры одним фрагментом
+func (m *fmp4Writer) flushFragment() error {
+	m.sequence++
+	fragment := buildFragment(m.sequence, m.decodeTime, m.samples)
+	for _, s := range m.samples {
+		m.decodeTime += uint64(s.duration)
+	}
+	m.samples = m.samples[:0]
+
+	_, err := m.w.Write(fragment)
+	return err
+}
+
+// ptsToTicks переводит разницу времени в единицы дорожки
+func ptsToTicks(d time.Duration) uint32 {
+	ticks := d * fmp4Timescale / time.Second
+	if ticks < 1 {
+		return 1
+	}
+	return uint32(ticks)
+}
+
+// annexBToAVCC переводит NAL-единицы кадра в формат с 4-байтовыми длинами.
+// Параметры и разделители кадров не нужны: они передаются в avcC.
+func annexBToAVCC(nalus [][]byte) []byte {
+	var out []byte
+	for _, nalu := range nalus {
+		switch h264.Type(nalu) {
+		case h264.NALTypeSPS, h264.NALTypePPS, h264.NALTypeAUD:
+			continue
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(nalu)))
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+// buildInitSegment формирует ftyp+moov для одной видеодорожки
+func buildInitSegment(sps, pps []byte) ([]byte, error) {
+	info, err := h264.ParseSPS(sps)
+	if err != nil {
+		return nil, err
+	}
+
+	ftyp := mp4Box("ftyp",
+		[]byte("isom"), u32(0x200),
+		[]byte("isom"), []byte("iso6"), []byte("avc1"), []byte("mp41"),
+	)
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation_time, modification_time
+		u32(1000), u32(0), // timescale, duration
+		u32(0x00010000), u16(0x0100), make([]byte, 10), // rate, volume, reserved
+		identityMatrix(),
+		make([]byte, 24), // pre_defined
+		u32(2),           // next_track_ID
+	)
+
+	tkhd := mp4FullBox("tkhd", 0, 3,
+		u32(0), u32(0), u32(1), u32(0), u32(0), // times, track_ID, reserved, duration
+		make([]byte, 8),                // reserved
+		u16(0), u16(0), u16(0), u16(0), // layer, alternate_group, volume, reserved
+		identityMatrix(),
+		u32(uint32(info.Width)<<16), u32(uint32(info.Height)<<16),
+	)
+
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		u32(0), u32(0), u32(fmp4Timescale), u32(0),
+		u16(0x55c4), u16(0), // язык "und"
+	)
+	hdlr := mp4FullBox("hdlr", 0, 0,
+		u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"),
+	)
+
+	avcC := mp4Box("avcC", buildAVCDecoderConfig(sps, pps, info))
+	avc1 := mp4Box("avc1",
+		make([]byte, 6), u16(1), // reserved, data_reference_index
+		make([]byte, 16), // pre_defined, reserved
+		u16(uint16(info.Width)), u16(uint16(info.Height)),
+		u32(0x00480000), u32(0x00480000), u32(0), // разрешение 72 dpi, reserved
+		u16(1), make([]byte, 32), // frame_count, compressorname
+		u16(0x0018), u16(0xffff), // depth, pre_defined
+		avcC,
+	)
+
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, u32(1), avc1),
+		mp4FullBox("stts", 0, 0, u32(0)),
+		mp4FullBox("stsc", 0, 0, u32(0)),
+		mp4FullBox("stsz", 0, 0, u32(0), u32(0)),
+		mp4FullBox("stco", 0, 0, u32(0)),
+	)
+	minf := mp4Box("minf",
+		mp4FullBox("vmhd", 0, 1, make([]byte, 8)),
+		mp4Box("dinf", mp4FullBox("dref", 0, 0, u32(1), mp4FullBox("url ", 0, 1))),
+		stbl,
+	)
+	trak := mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf))
+
+	mvex := mp4Box("mvex", mp4FullBox("trex", 0, 0,
+		u32(1), u32(1), u32(0), u32(0), u32(0), // track_ID, sample_description_index, defaults
+	))
+
+	moov := mp4Box("moov", mvhd, trak, mvex)
+	return append(ftyp, moov...), nil
+}
+
+// buildAVCDecoderConfig формирует содержимое avcC из SPS и PPS
+func buildAVCDecoderConfig(sps, pps []byte, info *h264.SPS) []byte {
+	out := []byte{1, info.ProfileIDC, info.ConstraintFlags, info.LevelIDC, 0xff, 0xe1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(sps)))
+	out = append(out, sps...)
+	out = append(out, 1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(pps)))
+	out = append(out, pps...)
+
+	switch info.ProfileIDC {
+	case 100, 110, 122, 144:
+		out = append(out,
+			0xfc|byte(info.ChromaFormatIDC),
+			0xf8|byte(info.BitDepthLuma-8),
+			0xf8|byte(info.BitDepthChroma-8),
+			0, // numOfSequenceParameterSetExt
+		)
+	}
+	return out
+}
+
+// buildFragment формирует moof+mdat для группы кадров
+func buildFragment(sequence uint32, decodeTime uint64, samples []fmp4Sample) []byte {
+	moof := buildMoof(sequence, decodeTime, samples, 0)
+	moof = buildMoof(sequence, decodeTime, samples, uint32(len(moof)+8))
+
+	size := 8
+	for _, s := range samples {
+		size += len(s.data)
+	}
+	out := make([]byte, 0, len(moof)+size)
+	out = append(out, moof...)
+	out = append(out, u32(uint32(size))...)
+	out = append(out, "mdat"...)
+	for _, s := range samples {
+		out = append(out, s.data...)
+	}
+	return out
+}
+
+// buildMoof формирует moof; dataOffset указывает на начало данных в mdat
+func buildMoof(sequence uint32, decodeTime uint64, samples []fmp4Sample, dataOffset uint32) []byte {
+	trun := make([]byte, 0, 8+len(samples)*12)
+	trun = binary.BigEndian.AppendUint32(trun, uint32(len(samples)))
+	trun = binary.BigEndian.AppendUint32(trun, dataOffset)
+	for _, s := range samples {
+		flags := uint32(sampleFlagsNonKeyframe)
+		if s.keyframe {
+			flags = sampleFlagsKeyframe
+		}
+		trun = binary.BigEndian.AppendUint32(trun, s.duration)
+		trun = binary.BigEndian.AppendUint32(trun, uint32(len(s.data)))
+		trun = binary.BigEndian.AppendUint32(trun, flags)
+	}
+
+	return mp4Box("moof",
+		mp4FullBox("mfhd", 0, 0, u32(sequence)),
+		mp4Box("traf",
+			mp4FullBox("tfhd", 0, 0x020000, u32(1)), // default-base-is-moof
+			mp4FullBox("tfdt", 1, 0, u64(decodeTime)),
+			mp4FullBox("trun", 0, 0x000701, trun), // data-offset, duration, size, flags
+		),
+	)
+}
+
+// mp4Box собирает бокс из заголовка и содержимого
+func mp4Box(boxType string, parts ...[]byte) []byte {
+	size := 8
+	for _, p := range parts {
+		size += len(p)
+	}
+	out := make([]byte, 0, size)
+	out = binary.BigEndian.AppendUint32(out, uint32(size))
+	out = append(out, boxType...)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// mp4FullBox собирает бокс с версией и флагами
+func mp4FullBox(boxType string, version byte, flags uint32, parts ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, parts...)...)
+}
+
+// identityMatrix возвращает единичную матрицу преобразования
+func identityMatrix() []byte {
+	var out []byte
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
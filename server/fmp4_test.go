@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// Параметры реальных потоков: Baseline 640x480 и High 1280x720
+var (
+	testBaselineSPS = []byte{0x67, 0x42, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64}
+	testHighSPS     = []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00,
+		0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60,
+	}
+	testPPS = []byte{0x68, 0xce, 0x38, 0x80}
+)
+
+// mp4TestBox — разобранный бокс: тип и содержимое без заголовка
+type mp4TestBox struct {
+	Type    string
+	Payload []byte
+	Size    int
+}
+
+// mp4Containers — боксы, содержимое которых целиком состоит из других боксов
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "dinf": true,
+	"stbl": true, "mvex": true, "moof": true, "traf": true,
+}
+
+// readBoxes разбирает последовательность боксов и проверяет, что их размеры
+// в точности покрывают данные, в том числе внутри вложенных контейнеров
+func readBoxes(t *testing.T, data []byte) []mp4TestBox {
+	t.Helper()
+	var boxes []mp4TestBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("неполный заголовок бокса: %d байт", len(data))
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("бокс %q: размер %d при оставшихся %d байтах", data[4:8], size, len(data))
+		}
+		box := mp4TestBox{Type: string(data[4:8]), Payload: data[8:size], Size: size}
+		if mp4Containers[box.Type] {
+			readBoxes(t, box.Payload)
+		}
+		boxes = append(boxes, box)
+		data = data[size:]
+	}
+	return boxes
+}
+
+// findBox возвращает содержимое бокса по пути из типов, например moof/traf/trun
+func findBox(t *testing.T, data []byte, path ...string) []byte {
+	t.Helper()
+	for _, boxType := range path {
+		found := false
+		for _, box := range readBoxes(t, data) {
+			if box.Type == boxType {
+				data, found = box.Payload, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("нет бокса %s", boxType)
+		}
+	}
+	return data
+}
+
+// trunSample — запись о сэмпле из trun
+type trunSample struct {
+	Duration, Size, Flags uint32
+}
+
+// readTrun разбирает trun с полями data_offset, duration, size и flags
+func readTrun(t *testing.T, trun []byte) (uint32, []trunSample) {
+	t.Helper()
+	if flags := binary.BigEndian.Uint32(trun) & 0xffffff; flags != 0x000701 {
+		t.Fatalf("флаги trun %06x", flags)
+	}
+	count := int(binary.BigEndian.Uint32(trun[4:]))
+	offset := binary.BigEndian.Uint32(trun[8:])
+	if len(trun) != 12+count*12 {
+		t.Fatalf("trun на %d сэмплов занимает %d байт", count, len(trun))
+	}
+	samples := make([]trunSample, count)
+	for i := range samples {
+		entry := trun[12+i*12:]
+		samples[i] = trunSample{
+			Duration: binary.BigEndian.Uint32(entry),
+			Size:     binary.BigEndian.Uint32(entry[4:]),
+			Flags:    binary.BigEndian.Uint32(entry[8:]),
+		}
+	}
+	return offset, samples
+}
+
+// avcc переводит NAL-единицу в запись с 4-байтовой длиной
+func avcc(nalu []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(nalu))), nalu...)
+}
+
+func TestFMP4WriterTwoGOPs(t *testing.T) {
+	idr1 := []byte{0x65, 0x88, 0x84, 0x01}
+	p1 := []byte{0x41, 0x9a, 0x02}
+	p2 := []byte{0x41, 0x9a, 0x04, 0x05}
+	idr2 := []byte{0x65, 0x88, 0x84, 0x02, 0x03}
+	p3 := []byte{0x41, 0x9a, 0x06}
+	frame := 40 * time.Millisecond
+
+	var out bytes.Buffer
+	m := newFMP4Writer(&out, nil, nil)
+	units := []*h264.AccessUnit{
+		{NALUs: [][]byte{{0x09, 0xf0}, testHighSPS, testPPS, idr1}, PTS: 0, Keyframe: true},
+		{NALUs: [][]byte{p1}, PTS: frame},
+		{NALUs: [][]byte{p2}, PTS: 2 * frame},
+		{NALUs: [][]byte{testHighSPS, testPPS, idr2}, PTS: 3 * frame, Keyframe: true},
+		{NALUs: [][]byte{p3}, PTS: 4 * frame},
+	}
+	for _, au := range units {
+		if err := m.WriteAccessUnit(au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := readBoxes(t, out.Bytes())
+	var types []string
+	for _, box := range boxes {
+		types = append(types, box.Type)
+	}
+	if got := strings.Join(types, " "); got != "ftyp moov moof mdat moof mdat" {
+		t.Fatalf("боксы верхнего уровня: %s", got)
+	}
+
+	ticks := uint32(frame * fmp4Timescale / time.Second)
+	gops := []struct {
+		sequence   uint32
+		decodeTime uint64
+		data       [][]byte
+		durations  []uint32
+	}{
+		// Параметры и разделитель кадров передаются в avcC, а не в сэмплах
+		{1, 0, [][]byte{avcc(idr1), avcc(p1), avcc(p2)}, []uint32{ticks, ticks, ticks}},
+		// Длительность последнего кадра повторяет предыдущую
+		{2, uint64(3 * ticks), [][]byte{avcc(idr2), avcc(p3)}, []uint32{ticks, ticks}},
+	}
+	for i, gop := range gops {
+		moof, mdat := boxes[2+2*i], boxes[3+2*i]
+		if seq := binary.BigEndian.Uint32(findBox(t, moof.Payload, "mfhd")[4:]); seq != gop.sequence {
+			t.Errorf("фрагмент %d: sequence_number %d", i, seq)
+		}
+		if tfdt := binary.BigEndian.Uint64(findBox(t, moof.Payload, "traf", "tfdt")[4:]); tfdt != gop.decodeTime {
+			t.Errorf("фрагмент %d: baseMediaDecodeTime %d, ожидалось %d", i, tfdt, gop.decodeTime)
+		}
+
+		// data_offset отсчитывается от начала moof (default-base-is-moof) и указывает на данные mdat
+		offset, samples := readTrun(t, findBox(t, moof.Payload, "traf", "trun"))
+		if want := uint32(moof.Size + 8); offset != want {
+			t.Errorf("фрагмент %d: data_offset %d, ожидалось %d", i, offset, want)
+		}
+		if !bytes.Equal(mdat.Payload, bytes.Join(gop.data, nil)) {
+			t.Errorf("фрагмент %d: mdat % x", i, mdat.Payload)
+		}
+		if len(samples) != len(gop.data) {
+			t.Fatalf("фрагмент %d: %d сэмплов, ожидалось %d", i, len(samples), len(gop.data))
+		}
+		for j, s := range samples {
+			flags := uint32(sampleFlagsNonKeyframe)
+			if j == 0 {
+				flags = sampleFlagsKeyframe
+			}
+			if s.Size != uint32(len(gop.data[j])) || s.Duration != gop.durations[j] || s.Flags != flags {
+				t.Errorf("фрагмент %d, сэмпл %d: %+v", i, j, s)
+			}
+		}
+	}
+}
+
+func TestFMP4WriterWaitsForKeyframe(t *testing.T) {
+	var out bytes.Buffer
+	m := newFMP4Writer(&out, nil, nil)
+
+	// До SPS, PPS и ключевого кадра писать нечего: декодер не сможет начать воспроизведение
+	for _, au := range []*h264.AccessUnit{
+		{NALUs: [][]byte{{0x41, 0x9a}}},
+		{NALUs: [][]byte{{0x65, 0x88}}, Keyframe: true},
+	} {
+		if err := m.WriteAccessUnit(au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("записано %d байт до первого ключевого кадра с параметрами", out.Len())
+	}
+
+	// С параметрами, полученными раньше (в прошлом сегменте), файл начинается с первого ключевого кадра
+	m = newFMP4Writer(&out, testHighSPS, testPPS)
+	if err := m.WriteAccessUnit(&h264.AccessUnit{NALUs: [][]byte{{0x65, 0x88}}, Keyframe: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, box := range readBoxes(t, out.Bytes()) {
+		types = append(types, box.Type)
+	}
+	if got := strings.Join(types, " "); got != "ftyp moov moof mdat" {
+		t.Errorf("боксы с сохраненными параметрами: %s", got)
+	}
+}
+
+func TestBuildInitSegment(t *testing.T) {
+	tests := []struct {
+		name          string
+		sps           []byte
+		width, height int
+		extension     []byte
+	}{
+		{"Baseline", testBaselineSPS, 640, 480, nil},
+		// Для профилей High в avcC добавляются формат цветности и разрядность
+		{"High", testHighSPS, 1280, 720, []byte{0xfd, 0xf8, 0xf8, 0x00}},
+	}
+	for _, tt := range tests {
+		init, err := buildInitSegment(tt.sps, testPPS)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		boxes := readBoxes(t, init)
+		if len(boxes) != 2 || boxes[0].Type != "ftyp" || boxes[1].Type != "moov" {
+			t.Fatalf("%s: ожидались ftyp и moov", tt.name)
+		}
+
+		tkhd := findBox(t, boxes[1].Payload, "trak", "tkhd")
+		width, height := binary.BigEndian.Uint32(tkhd[76:]), binary.BigEndian.Uint32(tkhd[80:])
+		if width != uint32(tt.width)<<16 || height != uint32(tt.height)<<16 {
+			t.Errorf("%s: tkhd %dx%d", tt.name, width>>16, height>>16)
+		}
+
+		// stsd: версия и флаги, число записей, затем avc1 с avcC в конце
+		stsd := findBox(t, boxes[1].Payload, "trak", "mdia", "minf", "stbl", "stsd")
+		avc1 := readBoxes(t, stsd[8:])
+		if len(avc1) != 1 || avc1[0].Type != "avc1" {
+			t.Fatalf("%s: в stsd нет avc1", tt.name)
+		}
+		if w, h := binary.BigEndian.Uint16(avc1[0].Payload[24:]), binary.BigEndian.Uint16(avc1[0].Payload[26:]); int(w) != tt.width || int(h) != tt.height {
+			t.Errorf("%s: avc1 %dx%d", tt.name, w, h)
+		}
+		avcC := readBoxes(t, avc1[0].Payload[78:])
+		if len(avcC) != 1 || avcC[0].Type != "avcC" {
+			t.Fatalf("%s: в avc1 нет avcC", tt.name)
+		}
+
+		want := []byte{1, tt.sps[1], tt.sps[2], tt.sps[3], 0xff, 0xe1}
+		want = binary.BigEndian.AppendUint16(want, uint16(len(tt.sps)))
+		want = append(want, tt.sps...)
+		want = append(want, 1)
+		want = binary.BigEndian.AppendUint16(want, uint16(len(testPPS)))
+		want = append(want, testPPS...)
+		want = append(want, tt.extension...)
+		if !bytes.Equal(avcC[0].Payload, want) {
+			t.Errorf("%s: avcC % x,\nожидалось % x", tt.name, avcC[0].Payload, want)
+		}
+	}
+
+	if _, err := buildInitSegment([]byte{0x67}, testPPS); err == nil {
+		t.Error("испорченный SPS: ожидалась ошибка")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Формат бинарного сообщения с заголовком (согласуется полем framed в hello и accept).
+// Сообщение содержит один или несколько кадров подряд; заголовок кадра, big-endian:
+//
+//	0      версия формата (frameVersion)
+//	1      флаги (frameFlagKeyframe)
+//	2..3   идентификатор дорожки
+//	4..7   порядковый номер кадра
+//	8..15  время захвата от начала потока, мкс
+//	16..19 длина данных кадра
+//
+// Данные кадра — H.264 Annex-B и заканчиваются на границе NAL-единицы.
+const (
+	frameVersion      = 1
+	frameHeaderSize   = 20
+	frameFlagKeyframe = 0x01
+)
+
+// videoTrackID — дорожка с видео камеры; другие дорожки сервер пока не записывает
+const videoTrackID = 0
+
+// wireFrame — кадр из сообщения с заголовком
+type wireFrame struct {
+	Track    uint16
+	Sequence uint32
+	PTS      time.Duration // время захвата от начала потока
+	Keyframe bool          // клиент отметил кадр как ключевой
+	Payload  []byte
+}
+
+// parseFrames разбирает сообщение с заголовками на кадры
+func parseFrames(message []byte) ([]wireFrame, error) {
+	var frames []wireFrame
+	for len(message) > 0 {
+		if len(message) < frameHeaderSize {
+			return nil, fmt.Errorf("неполный заголовок кадра: %d байт", len(message))
+		}
+		if message[0] != frameVersion {
+			return nil, fmt.Errorf("неподдерживаемая версия заголовка кадра %d", message[0])
+		}
+		length := binary.BigEndian.Uint32(message[16:20])
+		if uint64(length) > uint64(len(message)-frameHeaderSize) {
+			return nil, fmt.Errorf("длина кадра %d больше оставшихся %d байт сообщения", length, len(message)-frameHeaderSize)
+		}
+		pts := binary.BigEndian.Uint64(message[8:16])
+		if pts > uint64(time.Duration(1<<63-1)/time.Microsecond) {
+			return nil, fmt.Errorf("некорректное время кадра %d мкс", pts)
+		}
+
+		end := frameHeaderSize + int(length)
+		frames = append(frames, wireFrame{
+			Track:    binary.BigEndian.Uint16(message[2:4]),
+			Sequence: binary.BigEndian.Uint32(message[4:8]),
+			PTS:      time.Duration(pts) * time.Microsecond,
+			Keyframe: message[1]&frameFlagKeyframe != 0,
+			Payload:  message[frameHeaderSize:end],
+		})
+		message = message[end:]
+	}
+	return frames, nil
+}
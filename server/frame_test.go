@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frameMessage собирает кадр с заголовком; length задает поле длины независимо от данных
+func frameMessage(flags byte, track uint16, sequence uint32, pts uint64, length uint32, payload []byte) []byte {
+	message := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	message[0] = frameVersion
+	message[1] = flags
+	binary.BigEndian.PutUint16(message[2:4], track)
+	binary.BigEndian.PutUint32(message[4:8], sequence)
+	binary.BigEndian.PutUint64(message[8:16], pts)
+	binary.BigEndian.PutUint32(message[16:20], length)
+	return append(message, payload...)
+}
+
+func TestParseFrames(t *testing.T) {
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}
+	slice := []byte{0, 0, 0, 1, 0x41, 0x9a}
+
+	var message []byte
+	message = append(message, frameMessage(frameFlagKeyframe, videoTrackID, 7, 40_000, uint32(len(idr)), idr)...)
+	message = append(message, frameMessage(0, videoTrackID, 8, 80_000, uint32(len(slice)), slice)...)
+	message = append(message, frameMessage(0xfe, 3, 1<<32-1, 0, 0, nil)...)
+
+	frames, err := parseFrames(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []wireFrame{
+		{Track: videoTrackID, Sequence: 7, PTS: 40 * time.Millisecond, Keyframe: true, Payload: idr},
+		{Track: videoTrackID, Sequence: 8, PTS: 80 * time.Millisecond, Payload: slice},
+		{Track: 3, Sequence: 1<<32 - 1, Payload: []byte{}},
+	}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("получено %+v,\nожидалось %+v", frames, want)
+	}
+
+	if frames, err := parseFrames(nil); err != nil || len(frames) != 0 {
+		t.Errorf("пустое сообщение: %d кадров, ошибка %v", len(frames), err)
+	}
+}
+
+func TestParseFramesMaxPTS(t *testing.T) {
+	maxPTS := uint64(time.Duration(1<<63-1) / time.Microsecond)
+	frames, err := parseFrames(frameMessage(0, videoTrackID, 0, maxPTS, 0, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames[0].PTS != time.Duration(maxPTS)*time.Microsecond {
+		t.Errorf("PTS = %v", frames[0].PTS)
+	}
+}
+
+func TestParseFramesInvalid(t *testing.T) {
+	payload := []byte{0, 0, 0, 1, 0x65}
+	valid := frameMessage(frameFlagKeyframe, videoTrackID, 0, 0, uint32(len(payload)), payload)
+	badVersion := bytes.Clone(valid)
+	badVersion[0] = frameVersion + 1
+
+	tests := map[string]struct {
+		message []byte
+		want    string
+	}{
+		"неполный заголовок":           {valid[:frameHeaderSize-1], "неполный заголовок кадра: 19 байт"},
+		"обрезанные данные":            {valid[:len(valid)-1], "длина кадра 5 больше оставшихся 4 байт"},
+		"длина за пределами":           {frameMessage(0, videoTrackID, 0, 0, 1<<32-1, payload), "длина кадра 4294967295 больше"},
+		"хвост после кадра":            {append(bytes.Clone(valid), 1, 0, 0), "неполный заголовок кадра: 3 байт"},
+		"второй кадр обрезан":          {append(bytes.Clone(valid), valid[:len(valid)-2]...), "длина кадра 5 больше оставшихся 3 байт"},
+		"неизвестная версия":           {badVersion, "неподдерживаемая версия заголовка кадра 2"},
+		"переполнение времени":         {frameMessage(0, videoTrackID, 0, uint64(time.Duration(1<<63-1)/time.Microsecond)+1, 0, nil), "некорректное время кадра"},
+		"максимальное 64-битное время": {frameMessage(0, videoTrackID, 0, 1<<64-1, 0, nil), "некорректное время кадра"},
+	}
+	for name, tt := range tests {
+		frames, err := parseFrames(tt.message)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ошибка %v, ожидалась содержащая %q", name, err, tt.want)
+		}
+		if frames != nil {
+			t.Errorf("%s: при ошибке возвращены кадры %+v", name, frames)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package h264
+
+import "time"
+
+// AccessUnit — набор NAL-единиц одного кадра
+type AccessUnit struct {
+	NALUs    [][]byte
+	PTS      time.Duration
+	Keyframe bool
+}
+
+// HasVCL сообщает, содержит ли кадр слайсы изображения
+func (au *AccessUnit) HasVCL() bool {
+	for _, nalu := range au.NALUs {
+		if Type(nalu).IsVCL() {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnexB возвращает кадр в виде потока Annex-B
+func (au *AccessUnit) AnnexB() []byte {
+	size := 0
+	for _, nalu := range au.NALUs {
+		size += 4 + len(nalu)
+	}
+	out := make([]byte, 0, size)
+	for _, nalu := range au.NALUs {
+		out = append(out, 0, 0, 0, 1)
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+// Assembler группирует NAL-единицы в кадры
+type Assembler struct {
+	current *AccessUnit
+	seenVCL bool
+}
+
+// Push добавляет NAL-единицу и возвращает предыдущий кадр, если nalu начинает новый
+func (a *Assembler) Push(nalu []byte, pts time.Duration) *AccessUnit {
+	var done *AccessUnit
+	if a.current != nil && a.seenVCL && StartsAccessUnit(nalu) {
+		done = a.current
+		a.current = nil
+	}
+
+	if a.current == nil {
+		a.current = &AccessUnit{PTS: pts}
+		a.seenVCL = false
+	}
+
+	t := Type(nalu)
+	a.current.NALUs = append(a.current.NALUs, nalu)
+	if t.IsVCL() {
+		a.seenVCL = true
+	}
+	if t == NALTypeIDR {
+		a.current.Keyframe = true
+	}
+
+	return done
+}
+
+// Flush возвращает накопленный кадр
+func (a *Assembler) Flush() *AccessUnit {
+	done := a.current
+	a.current = nil
+	a.seenVCL = false
+	if done == nil || !done.HasVCL() {
+		return nil
+	}
+	return done
+}
+
+// StartsAccessUnit проверяет, может ли NAL-единица начинать новый кадр
+func StartsAccessUnit(nalu []byte) bool {
+	switch t := Type(nalu); {
+	case t == NALTypeAUD, t == NALTypeSPS, t == NALTypePPS, t == NALTypeSEI:
+		return true
+	case t >= 14 && t <= 18:
+		return true
+	case t.IsVCL():
+		// first_mb_in_slice == 0 кодируется единичным битом ue(v)
+		return len(nalu) > 1 && nalu[1]&0x80 != 0
+	}
+	return false
+}
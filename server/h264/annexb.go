@@ -0,0 +1,103 @@
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxNALSize — предел размера одной NAL-единицы, если в Splitter не задан другой
+const DefaultMaxNALSize = 8 << 20
+
+// ErrInvalidStream оборачивает все ошибки, по которым поток нельзя считать H.264 Annex-B
+var ErrInvalidStream = errors.New("некорректный поток H.264")
+
+var startCode = []byte{0, 0, 1}
+
+// Splitter делит поток Annex-B на NAL-единицы.
+// Единица может быть разрезана между сообщениями: хвост хранится до следующего стартового кода.
+type Splitter struct {
+	MaxNALSize int // предел размера незавершенной единицы (0 — DefaultMaxNALSize)
+
+	buf      []byte
+	scanFrom int
+	skipped  int
+}
+
+// Push добавляет данные и возвращает завершенные NAL-единицы.
+// Ошибка означает, что незавершенная единица превысила MaxNALSize; ее данные отбрасываются.
+func (s *Splitter) Push(data []byte) ([][]byte, error) {
+	s.buf = append(s.buf, data...)
+
+	var nalus [][]byte
+	start, i := -1, s.scanFrom
+	if bytes.HasPrefix(s.buf, startCode) {
+		start = len(startCode)
+		i = max(i, start)
+	}
+
+	for {
+		idx := bytes.Index(s.buf[i:], startCode)
+		if idx < 0 {
+			break
+		}
+		idx += i
+		if start >= 0 {
+			if nalu := bytes.TrimRight(s.buf[start:idx], "\x00"); len(nalu) > 0 {
+				nalus = append(nalus, bytes.Clone(nalu))
+			}
+		} else {
+			s.skipped += idx
+		}
+		start = idx + len(startCode)
+		i = start
+	}
+
+	if start < 0 {
+		// Стартовый код еще не встречался: оставляем только возможное начало кода
+		if len(s.buf) > 2 {
+			s.skipped += len(s.buf) - 2
+			s.buf = append(s.buf[:0], s.buf[len(s.buf)-2:]...)
+		}
+		s.scanFrom = 0
+		return nalus, nil
+	}
+
+	// Оставляем незавершенную единицу вместе с ее стартовым кодом
+	s.buf = append(s.buf[:0], s.buf[start-len(startCode):]...)
+	s.scanFrom = max(len(s.buf)-2, len(startCode))
+
+	maxSize := s.MaxNALSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxNALSize
+	}
+	if size := len(s.buf) - len(startCode); size > maxSize {
+		s.Reset()
+		return nalus, fmt.Errorf("%w: NAL-единица больше %d байт", ErrInvalidStream, maxSize)
+	}
+	return nalus, nil
+}
+
+// Flush возвращает последнюю незавершенную NAL-единицу
+func (s *Splitter) Flush() []byte {
+	var nalu []byte
+	if bytes.HasPrefix(s.buf, startCode) {
+		nalu = bytes.Clone(bytes.TrimRight(s.buf[len(startCode):], "\x00"))
+	}
+	s.Reset()
+	if len(nalu) == 0 {
+		return nil
+	}
+	return nalu
+}
+
+// Reset отбрасывает накопленные данные
+func (s *Splitter) Reset() {
+	s.buf = s.buf[:0]
+	s.scanFrom = 0
+}
+
+// Skipped возвращает число байт, отброшенных до первого стартового кода
+func (s *Splitter) Skipped() int {
+	return s.skipped
+}
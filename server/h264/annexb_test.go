@@ -0,0 +1,112 @@
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// annexB склеивает NAL-единицы в поток Annex-B с четырехбайтовыми стартовыми кодами
+func annexB(nalus ...[]byte) []byte {
+	var out []byte
+	for _, nalu := range nalus {
+		out = append(out, 0, 0, 0, 1)
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+// splitAll пропускает поток через Splitter порциями по chunk байт
+func splitAll(t testing.TB, data []byte, chunk int) [][]byte {
+	t.Helper()
+	var s Splitter
+	var nalus [][]byte
+	for i := 0; i < len(data); i += chunk {
+		out, err := s.Push(data[i:min(i+chunk, len(data))])
+		if err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		nalus = append(nalus, out...)
+	}
+	if nalu := s.Flush(); nalu != nil {
+		nalus = append(nalus, nalu)
+	}
+	return nalus
+}
+
+func TestSplitterChunking(t *testing.T) {
+	want := [][]byte{
+		{0x67, 0x42, 0xc0, 0x1e},
+		{0x68, 0xce, 0x38, 0x80},
+		{0x65, 0x88, 0x84, 0x00, 0x00, 0x03, 0x01},
+		{0x41, 0x9a, 0x02},
+	}
+	stream := annexB(want...)
+
+	// Стартовый код и сама единица могут оказаться разрезаны в любом месте
+	for chunk := 1; chunk <= len(stream); chunk++ {
+		if got := splitAll(t, stream, chunk); !reflect.DeepEqual(got, want) {
+			t.Fatalf("порции по %d байт: получено %x, ожидалось %x", chunk, got, want)
+		}
+	}
+}
+
+func TestSplitterStartCodes(t *testing.T) {
+	stream := []byte{
+		0xff, 0xfe, // мусор до первого стартового кода
+		0, 0, 1, 0x09, 0xf0,
+		0, 0, 0, 1, 0x67, 0x42,
+		0, 0, 1, 0x68, 0xce,
+	}
+	want := [][]byte{{0x09, 0xf0}, {0x67, 0x42}, {0x68, 0xce}}
+
+	var s Splitter
+	got, err := s.Push(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, s.Flush())
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("получено %x, ожидалось %x", got, want)
+	}
+	if s.Skipped() != 2 {
+		t.Errorf("Skipped() = %d, ожидалось 2", s.Skipped())
+	}
+}
+
+func TestSplitterMaxNALSize(t *testing.T) {
+	s := Splitter{MaxNALSize: 16}
+	if _, err := s.Push(annexB(bytes.Repeat([]byte{0x65}, 10))); err != nil {
+		t.Fatalf("единица в пределах ограничения: %v", err)
+	}
+	_, err := s.Push(bytes.Repeat([]byte{0x65}, 10))
+	if !errors.Is(err, ErrInvalidStream) {
+		t.Fatalf("ожидалась ошибка ErrInvalidStream, получено %v", err)
+	}
+	if nalu := s.Flush(); nalu != nil {
+		t.Errorf("после ошибки осталась единица %x", nalu)
+	}
+}
+
+func FuzzSplitter(f *testing.F) {
+	f.Add(annexB([]byte{0x67, 0x42}, []byte{0x65, 0x88, 0, 0, 3, 1}), 3)
+	f.Add([]byte{0, 0, 0, 0, 1, 0, 0, 1, 0, 0}, 1)
+	f.Add([]byte{1, 2, 3}, 2)
+
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		if chunk <= 0 || chunk > len(data) {
+			chunk = max(len(data), 1)
+		}
+		whole := splitAll(t, data, max(len(data), 1))
+		parts := splitAll(t, data, chunk)
+		if !reflect.DeepEqual(whole, parts) {
+			t.Fatalf("результат зависит от нарезки: %x и %x", whole, parts)
+		}
+		for _, nalu := range whole {
+			if len(nalu) == 0 || bytes.Contains(nalu, startCode) {
+				t.Fatalf("некорректная NAL-единица %x", nalu)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+// Package h264 разбирает видеопоток H.264 в формате Annex-B: делит его на NAL-единицы,
+// собирает из них кадры и извлекает параметры потока из SPS.
+package h264
+
+import "fmt"
+
+// NALType — тип NAL-единицы (nal_unit_type)
+type NALType uint8
+
+// Типы NAL-единиц, которые важны серверу
+const (
+	NALTypeNonIDR NALType = 1
+	NALTypeIDR    NALType = 5
+	NALTypeSEI    NALType = 6
+	NALTypeSPS    NALType = 7
+	NALTypePPS    NALType = 8
+	NALTypeAUD    NALType = 9
+)
+
+// Type возвращает тип NAL-единицы или 0 для пустой единицы
+func Type(nalu []byte) NALType {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return NALType(nalu[0] & 0x1f)
+}
+
+// IsVCL сообщает, содержит ли единица слайс изображения
+func (t NALType) IsVCL() bool {
+	return t == NALTypeNonIDR || t == NALTypeIDR
+}
+
+// String возвращает краткое название типа для журнала
+func (t NALType) String() string {
+	switch t {
+	case NALTypeNonIDR:
+		return "non-IDR"
+	case NALTypeIDR:
+		return "IDR"
+	case NALTypeSEI:
+		return "SEI"
+	case NALTypeSPS:
+		return "SPS"
+	case NALTypePPS:
+		return "PPS"
+	case NALTypeAUD:
+		return "AUD"
+	}
+	return fmt.Sprintf("NAL %d", uint8(t))
+}
+
+// checkNAL проверяет заголовок NAL-единицы
+func checkNAL(nalu []byte) error {
+	if nalu[0]&0x80 != 0 {
+		return fmt.Errorf("%w: установлен forbidden_zero_bit", ErrInvalidStream)
+	}
+	if Type(nalu) == 0 {
+		return fmt.Errorf("%w: NAL-единица неопределенного типа 0", ErrInvalidStream)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package h264
+
+// ParameterSets хранит последние SPS и PPS потока, чтобы декодирование
+// можно было начать с любого ключевого кадра
+type ParameterSets struct {
+	SPS []byte
+	PPS []byte
+}
+
+// Update запоминает SPS и PPS, если они есть в кадре
+func (p *ParameterSets) Update(au *AccessUnit) {
+	for _, nalu := range au.NALUs {
+		switch Type(nalu) {
+		case NALTypeSPS:
+			p.SPS = nalu
+		case NALTypePPS:
+			p.PPS = nalu
+		}
+	}
+}
+
+// Complete сообщает, получены ли и SPS, и PPS
+func (p *ParameterSets) Complete() bool {
+	return p.SPS != nil && p.PPS != nil
+}
+
+// Inject возвращает кадр, начинающийся с сохраненных SPS и PPS.
+// Кадр, в котором уже есть оба набора, или кадр при неполных наборах возвращается без изменений.
+func (p *ParameterSets) Inject(au *AccessUnit) *AccessUnit {
+	hasSPS, hasPPS := false, false
+	for _, nalu := range au.NALUs {
+		switch Type(nalu) {
+		case NALTypeSPS:
+			hasSPS = true
+		case NALTypePPS:
+			hasPPS = true
+		}
+	}
+	if hasSPS && hasPPS || !p.Complete() {
+		return au
+	}
+
+	// Разделитель кадров, если он есть, должен остаться первым
+	nalus := make([][]byte, 0, len(au.NALUs)+2)
+	rest := au.NALUs
+	if len(rest) > 0 && Type(rest[0]) == NALTypeAUD {
+		nalus = append(nalus, rest[0])
+		rest = rest[1:]
+	}
+	nalus = append(nalus, p.SPS, p.PPS)
+	for _, nalu := range rest {
+		if t := Type(nalu); t != NALTypeSPS && t != NALTypePPS {
+			nalus = append(nalus, nalu)
+		}
+	}
+	return &AccessUnit{NALUs: nalus, PTS: au.PTS, Keyframe: au.Keyframe}
+}
@@ -0,0 +1,38 @@
+package h264
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParameterSetsInject(t *testing.T) {
+	sps := baselineSPS(40, 30, [4]uint{}, 0)
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	aud := []byte{0x09, 0xf0}
+	idr := []byte{0x65, 0x88, 0x84}
+
+	var params ParameterSets
+	keyframe := &AccessUnit{NALUs: [][]byte{aud, idr}, Keyframe: true}
+	if got := params.Inject(keyframe); got != keyframe {
+		t.Fatal("без сохраненных наборов кадр должен остаться без изменений")
+	}
+
+	params.Update(&AccessUnit{NALUs: [][]byte{sps, pps, idr}, Keyframe: true})
+	if !params.Complete() {
+		t.Fatal("Complete() = false после кадра с SPS и PPS")
+	}
+
+	got := params.Inject(keyframe)
+	if want := [][]byte{aud, sps, pps, idr}; !reflect.DeepEqual(got.NALUs, want) {
+		t.Fatalf("получено %x, ожидалось %x", got.NALUs, want)
+	}
+	if !got.Keyframe || len(keyframe.NALUs) != 2 {
+		t.Error("Inject изменил исходный кадр или потерял признак ключевого")
+	}
+
+	// Кадр только с PPS получает оба набора без дублирования
+	got = params.Inject(&AccessUnit{NALUs: [][]byte{pps, idr}, Keyframe: true})
+	if want := [][]byte{sps, pps, idr}; !reflect.DeepEqual(got.NALUs, want) {
+		t.Fatalf("получено %x, ожидалось %x", got.NALUs, want)
+	}
+}
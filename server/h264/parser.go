@@ -0,0 +1,103 @@
+package h264
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// maxLeadingGarbage — сколько байт без стартового кода допускается в начале потока
+const maxLeadingGarbage = 64 << 10
+
+// Parser собирает кадры из произвольно нарезанного потока Annex-B,
+// проверяет заголовки NAL-единиц и запоминает параметры потока из SPS
+type Parser struct {
+	Splitter Splitter
+
+	assembler Assembler
+	seenNAL   bool
+	sps       *SPS
+	rawSPS    []byte
+}
+
+// Push разбирает очередную порцию данных и возвращает завершенные кадры.
+// Ошибка, оборачивающая ErrInvalidStream, означает, что данные не похожи на H.264 Annex-B.
+func (p *Parser) Push(data []byte, pts time.Duration) ([]*AccessUnit, error) {
+	nalus, err := p.Splitter.Push(data)
+
+	var units []*AccessUnit
+	for _, nalu := range nalus {
+		if err := p.inspect(nalu); err != nil {
+			return units, err
+		}
+		if au := p.assembler.Push(nalu, pts); au != nil {
+			units = append(units, au)
+		}
+	}
+	if err != nil {
+		return units, err
+	}
+
+	if !p.seenNAL && p.Splitter.Skipped() > maxLeadingGarbage {
+		return units, fmt.Errorf("%w: нет стартового кода Annex-B в первых %d байтах", ErrInvalidStream, p.Splitter.Skipped())
+	}
+	return units, nil
+}
+
+// PushUnit разбирает данные, которые заканчиваются на границе NAL-единицы, например целый кадр
+// из сообщения с заголовком: последняя NAL-единица не ждет следующего стартового кода,
+// поэтому кадр получает метку времени своего сообщения.
+func (p *Parser) PushUnit(data []byte, pts time.Duration) ([]*AccessUnit, error) {
+	units, err := p.Push(data, pts)
+	if err != nil {
+		return units, err
+	}
+	if nalu := p.Splitter.Flush(); nalu != nil {
+		if err := p.inspect(nalu); err != nil {
+			return units, err
+		}
+		if au := p.assembler.Push(nalu, pts); au != nil {
+			units = append(units, au)
+		}
+	}
+	return units, nil
+}
+
+// Flush возвращает все оставшиеся кадры
+func (p *Parser) Flush(pts time.Duration) []*AccessUnit {
+	var units []*AccessUnit
+	if nalu := p.Splitter.Flush(); nalu != nil && p.inspect(nalu) == nil {
+		if au := p.assembler.Push(nalu, pts); au != nil {
+			units = append(units, au)
+		}
+	}
+	if au := p.assembler.Flush(); au != nil {
+		units = append(units, au)
+	}
+	return units
+}
+
+// SPS возвращает параметры потока из последнего SPS или nil, если SPS еще не встречался.
+// Значение заменяется новым только при изменении SPS.
+func (p *Parser) SPS() *SPS {
+	return p.sps
+}
+
+// inspect проверяет NAL-единицу и разбирает новый SPS
+func (p *Parser) inspect(nalu []byte) error {
+	if err := checkNAL(nalu); err != nil {
+		return err
+	}
+	p.seenNAL = true
+
+	if Type(nalu) != NALTypeSPS || bytes.Equal(nalu, p.rawSPS) {
+		return nil
+	}
+	sps, err := ParseSPS(nalu)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidStream, err)
+	}
+	p.sps = sps
+	p.rawSPS = nalu
+	return nil
+}
@@ -0,0 +1,162 @@
+package h264
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testGOP возвращает поток из ключевого кадра с параметрами и двух обычных кадров
+func testGOP() []byte {
+	return annexB(
+		[]byte{0x09, 0xf0},
+		baselineSPS(40, 30, [4]uint{}, 30),
+		[]byte{0x68, 0xce, 0x38, 0x80},
+		[]byte{0x06, 0x05, 0x01, 0x80},
+		[]byte{0x65, 0x88, 0x84, 0x00},
+		[]byte{0x41, 0x9a, 0x02},
+		[]byte{0x41, 0x9a, 0x04},
+	)
+}
+
+func TestParserAccessUnits(t *testing.T) {
+	stream := testGOP()
+	var p Parser
+	var units []*AccessUnit
+	for i := 0; i < len(stream); i += 5 {
+		out, err := p.Push(stream[i:min(i+5, len(stream))], time.Duration(i)*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		units = append(units, out...)
+	}
+	units = append(units, p.Flush(time.Second)...)
+
+	if len(units) != 3 {
+		t.Fatalf("получено кадров %d, ожидалось 3", len(units))
+	}
+	wantTypes := [][]NALType{
+		{NALTypeAUD, NALTypeSPS, NALTypePPS, NALTypeSEI, NALTypeIDR},
+		{NALTypeNonIDR},
+		{NALTypeNonIDR},
+	}
+	for i, au := range units {
+		if au.Keyframe != (i == 0) {
+			t.Errorf("кадр %d: Keyframe = %v", i, au.Keyframe)
+		}
+		if len(au.NALUs) != len(wantTypes[i]) {
+			t.Fatalf("кадр %d: %d NAL-единиц, ожидалось %d", i, len(au.NALUs), len(wantTypes[i]))
+		}
+		for j, nalu := range au.NALUs {
+			if Type(nalu) != wantTypes[i][j] {
+				t.Errorf("кадр %d, единица %d: %v, ожидалось %v", i, j, Type(nalu), wantTypes[i][j])
+			}
+		}
+	}
+
+	sps := p.SPS()
+	if sps == nil || sps.Width != 640 || sps.Height != 480 || sps.FrameRate != 30 {
+		t.Fatalf("SPS() = %v", sps)
+	}
+	if _, err := p.Push(testGOP(), 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if p.SPS() != sps {
+		t.Error("повторный одинаковый SPS заменил параметры потока")
+	}
+}
+
+func TestParserPushUnit(t *testing.T) {
+	frames := [][]byte{
+		annexB([]byte{0x09, 0xf0}, baselineSPS(40, 30, [4]uint{}, 30), []byte{0x68, 0xce, 0x38, 0x80}, []byte{0x65, 0x88, 0x84, 0x00}),
+		annexB([]byte{0x41, 0x9a, 0x02}),
+		annexB([]byte{0x41, 0x9a, 0x04}),
+	}
+
+	var p Parser
+	var units []*AccessUnit
+	for i, frame := range frames {
+		out, err := p.PushUnit(frame, time.Duration(i)*40*time.Millisecond)
+		if err != nil {
+			t.Fatalf("PushUnit: %v", err)
+		}
+		units = append(units, out...)
+	}
+	units = append(units, p.Flush(time.Second)...)
+
+	if len(units) != len(frames) {
+		t.Fatalf("получено кадров %d, ожидалось %d", len(units), len(frames))
+	}
+	for i, au := range units {
+		if want := time.Duration(i) * 40 * time.Millisecond; au.PTS != want {
+			t.Errorf("кадр %d: PTS = %v, ожидалось %v", i, au.PTS, want)
+		}
+		if au.Keyframe != (i == 0) {
+			t.Errorf("кадр %d: Keyframe = %v", i, au.Keyframe)
+		}
+	}
+}
+
+func TestParserRejectsInvalidData(t *testing.T) {
+	tests := map[string][]byte{
+		"forbidden_zero_bit": annexB([]byte{0xe5, 0x88}, []byte{0x41}),
+		"тип 0":              annexB([]byte{0x00, 0x88}, []byte{0x41}),
+		"испорченный SPS":    annexB([]byte{0x67, 0x42}, []byte{0x68, 0xce}),
+		"нет стартового кода": func() []byte {
+			data := make([]byte, 2*maxLeadingGarbage)
+			for i := range data {
+				data[i] = byte(i%250) + 1
+			}
+			return data
+		}(),
+	}
+	for name, data := range tests {
+		var p Parser
+		if _, err := p.Push(data, 0); !errors.Is(err, ErrInvalidStream) {
+			t.Errorf("%s: ожидалась ошибка ErrInvalidStream, получено %v", name, err)
+		}
+	}
+}
+
+func TestNALTypeString(t *testing.T) {
+	for nalType, want := range map[NALType]string{
+		NALTypeSPS:    "SPS",
+		NALTypePPS:    "PPS",
+		NALTypeIDR:    "IDR",
+		NALTypeNonIDR: "non-IDR",
+		NALTypeSEI:    "SEI",
+		20:            "NAL 20",
+	} {
+		if got := nalType.String(); got != want {
+			t.Errorf("NALType(%d).String() = %q, ожидалось %q", uint8(nalType), got, want)
+		}
+	}
+}
+
+func FuzzParser(f *testing.F) {
+	f.Add(testGOP(), 7)
+	f.Add(annexB([]byte{0x65, 0x88}, []byte{0x41, 0x9a}), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		if chunk <= 0 {
+			chunk = 1
+		}
+		var p Parser
+		for i := 0; i < len(data); i += chunk {
+			units, err := p.Push(data[i:min(i+chunk, len(data))], 0)
+			for _, au := range units {
+				if len(au.NALUs) == 0 {
+					t.Fatal("пустой кадр")
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+		for _, au := range p.Flush(0) {
+			if !au.HasVCL() {
+				t.Fatal("кадр без слайсов")
+			}
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SPS содержит параметры потока из Sequence Parameter Set
+type SPS struct {
+	ProfileIDC      uint8
+	ConstraintFlags uint8
+	LevelIDC        uint8
+	ChromaFormatIDC uint
+	BitDepthLuma    uint
+	BitDepthChroma  uint
+	Width           int
+	Height          int
+	FrameRate       float64 // частота кадров из VUI (0, если не указана)
+}
+
+// maxFrameSize — предел ширины и высоты кадра; больше не допускает ни один уровень H.264
+const maxFrameSize = 16384
+
+var errShortSPS = errors.New("SPS обрезан")
+
+// ParseSPS разбирает SPS вместе с параметрами синхронизации VUI
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 || Type(nalu) != NALTypeSPS {
+		return nil, errShortSPS
+	}
+
+	info := &SPS{
+		ProfileIDC:      nalu[1],
+		ConstraintFlags: nalu[2],
+		LevelIDC:        nalu[3],
+		ChromaFormatIDC: 1,
+		BitDepthLuma:    8,
+		BitDepthChroma:  8,
+	}
+
+	r := &bitReader{data: unescapeRBSP(nalu[4:])}
+	r.ue() // seq_parameter_set_id
+
+	switch info.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		info.ChromaFormatIDC = r.ue()
+		if info.ChromaFormatIDC == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		info.BitDepthLuma = r.ue() + 8
+		info.BitDepthChroma = r.ue() + 8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			count := 8
+			if info.ChromaFormatIDC == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if r.bit() == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(r, size)
+				}
+			}
+		}
+	}
+	if info.ChromaFormatIDC > 3 || info.BitDepthLuma > 14 || info.BitDepthChroma > 14 {
+		return nil, fmt.Errorf("недопустимые параметры цветности в SPS")
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := uint(0); i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMbsOnly := int(r.bit())
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+
+	info.Width = widthMbs * 16
+	info.Height = (2 - frameMbsOnly) * heightMapUnits * 16
+
+	if r.bit() == 1 {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, 2-frameMbsOnly
+		switch info.ChromaFormatIDC {
+		case 1:
+			cropX, cropY = 2, 2*(2-frameMbsOnly)
+		case 2:
+			cropX = 2
+		}
+		info.Width -= cropX * (left + right)
+		info.Height -= cropY * (top + bottom)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if info.Width <= 0 || info.Height <= 0 || info.Width > maxFrameSize || info.Height > maxFrameSize {
+		return nil, fmt.Errorf("недопустимый размер кадра в SPS: %dx%d", info.Width, info.Height)
+	}
+
+	// VUI необязателен: если он обрезан, частота кадров остается неизвестной
+	if r.bit() == 1 {
+		if fps := parseVUIFrameRate(r); r.err == nil {
+			info.FrameRate = fps
+		}
+	}
+	return info, nil
+}
+
+// parseVUIFrameRate читает VUI до параметров синхронизации и возвращает частоту кадров
+func parseVUIFrameRate(r *bitReader) float64 {
+	if r.bit() == 1 { // aspect_ratio_info_present_flag
+		if r.bits(8) == 255 { // aspect_ratio_idc == Extended_SAR
+			r.bits(16) // sar_width
+			r.bits(16) // sar_height
+		}
+	}
+	if r.bit() == 1 { // overscan_info_present_flag
+		r.bit() // overscan_appropriate_flag
+	}
+	if r.bit() == 1 { // video_signal_type_present_flag
+		r.bits(3) // video_format
+		r.bit()   // video_full_range_flag
+		if r.bit() == 1 {
+			r.bits(24) // colour_primaries, transfer_characteristics, matrix_coefficients
+		}
+	}
+	if r.bit() == 1 { // chroma_loc_info_present_flag
+		r.ue()
+		r.ue()
+	}
+	if r.bit() == 0 { // timing_info_present_flag
+		return 0
+	}
+
+	unitsInTick := r.bits(32)
+	timeScale := r.bits(32)
+	if unitsInTick == 0 {
+		return 0
+	}
+	// Один кадр состоит из двух полей, отсюда множитель 2
+	return float64(timeScale) / float64(2*unitsInTick)
+}
+
+// Profile возвращает название профиля кодирования
+func (s *SPS) Profile() string {
+	switch s.ProfileIDC {
+	case 66:
+		if s.ConstraintFlags&0x40 != 0 {
+			return "Constrained Baseline"
+		}
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4 Predictive"
+	}
+	return fmt.Sprintf("profile_idc %d", s.ProfileIDC)
+}
+
+// Level возвращает уровень в виде "3.1"
+func (s *SPS) Level() string {
+	if s.LevelIDC == 11 && s.ConstraintFlags&0x10 != 0 && (s.ProfileIDC == 66 || s.ProfileIDC == 77) {
+		return "1b"
+	}
+	return fmt.Sprintf("%d.%d", s.LevelIDC/10, s.LevelIDC%10)
+}
+
+// String возвращает параметры потока для журнала
+func (s *SPS) String() string {
+	text := fmt.Sprintf("%s@%s %dx%d", s.Profile(), s.Level(), s.Width, s.Height)
+	if s.FrameRate > 0 {
+		text += fmt.Sprintf(" %.4g fps", s.FrameRate)
+	}
+	return text
+}
+
+// skipScalingList пропускает матрицу квантования
+func skipScalingList(r *bitReader, size int) {
+	last, next := 8, 8
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
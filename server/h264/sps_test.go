@@ -0,0 +1,168 @@
+package h264
+
+import (
+	"testing"
+)
+
+// bitWriter собирает битовые поля RBSP для тестовых SPS
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) bit(v uint) {
+	if w.n%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	if v != 0 {
+		w.data[len(w.data)-1] |= 1 << (7 - w.n%8)
+	}
+	w.n++
+}
+
+func (w *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> i & 1)
+	}
+}
+
+func (w *bitWriter) ue(v uint) {
+	v++
+	n := 0
+	for t := v; t > 1; t >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+// baselineSPS формирует SPS профиля Baseline с заданным размером в макроблоках
+// и, если fps больше нуля, с параметрами синхронизации VUI
+func baselineSPS(widthMbs, heightMbs uint, crop [4]uint, fps uint) []byte {
+	w := &bitWriter{}
+	w.ue(0) // seq_parameter_set_id
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(0) // pic_order_cnt_type
+	w.ue(0) // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(1) // max_num_ref_frames
+	w.bit(0)
+	w.ue(widthMbs - 1)
+	w.ue(heightMbs - 1)
+	w.bit(1) // frame_mbs_only_flag
+	w.bit(1) // direct_8x8_inference_flag
+	if crop != [4]uint{} {
+		w.bit(1)
+		for _, v := range crop {
+			w.ue(v)
+		}
+	} else {
+		w.bit(0)
+	}
+	if fps > 0 {
+		w.bit(1)       // vui_parameters_present_flag
+		w.bit(1)       // aspect_ratio_info_present_flag
+		w.bits(255, 8) // Extended_SAR
+		w.bits(1, 16)
+		w.bits(1, 16)
+		w.bit(0) // overscan_info_present_flag
+		w.bit(1) // video_signal_type_present_flag
+		w.bits(5, 3)
+		w.bit(0)
+		w.bit(0) // colour_description_present_flag
+		w.bit(0) // chroma_loc_info_present_flag
+		w.bit(1) // timing_info_present_flag
+		w.bits(1, 32)
+		w.bits(2*fps, 32)
+		w.bit(1)
+	} else {
+		w.bit(0)
+	}
+	w.bit(1) // rbsp_stop_one_bit
+	return append([]byte{0x67, 66, 0xc0, 30}, w.data...)
+}
+
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		name    string
+		nalu    []byte
+		profile string
+		level   string
+		width   int
+		height  int
+		fps     float64
+	}{
+		{
+			name:    "x264 720p High",
+			nalu:    []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60},
+			profile: "High", level: "3.1", width: 1280, height: 720, fps: 30,
+		},
+		{
+			name:    "x264 1080p High с кадрированием",
+			nalu:    []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58},
+			profile: "High", level: "4.0", width: 1920, height: 1080, fps: 30,
+		},
+		{
+			name:    "Baseline без VUI",
+			nalu:    baselineSPS(40, 30, [4]uint{}, 0),
+			profile: "Constrained Baseline", level: "3.0", width: 640, height: 480,
+		},
+		{
+			name:    "Baseline с VUI и кадрированием",
+			nalu:    baselineSPS(20, 15, [4]uint{0, 4, 0, 2}, 25),
+			profile: "Constrained Baseline", level: "3.0", width: 312, height: 236, fps: 25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sps, err := ParseSPS(tt.nalu)
+			if err != nil {
+				t.Fatalf("ParseSPS: %v", err)
+			}
+			if sps.Profile() != tt.profile || sps.Level() != tt.level {
+				t.Errorf("профиль %s@%s, ожидался %s@%s", sps.Profile(), sps.Level(), tt.profile, tt.level)
+			}
+			if sps.Width != tt.width || sps.Height != tt.height {
+				t.Errorf("размер %dx%d, ожидался %dx%d", sps.Width, sps.Height, tt.width, tt.height)
+			}
+			if sps.FrameRate != tt.fps {
+				t.Errorf("частота кадров %v, ожидалась %v", sps.FrameRate, tt.fps)
+			}
+		})
+	}
+}
+
+func TestParseSPSErrors(t *testing.T) {
+	valid := baselineSPS(40, 30, [4]uint{}, 0)
+	tests := map[string][]byte{
+		"пустой":         nil,
+		"не SPS":         append([]byte{0x68}, valid[1:]...),
+		"обрезан":        valid[:5],
+		"нулевой размер": baselineSPS(1, 1, [4]uint{8, 8, 0, 0}, 0),
+	}
+	for name, nalu := range tests {
+		if sps, err := ParseSPS(nalu); err == nil {
+			t.Errorf("%s: ожидалась ошибка, получено %v", name, sps)
+		}
+	}
+}
+
+func FuzzParseSPS(f *testing.F) {
+	f.Add(baselineSPS(40, 30, [4]uint{}, 0))
+	f.Add(baselineSPS(20, 15, [4]uint{0, 4, 0, 2}, 25))
+	f.Add([]byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60})
+
+	f.Fuzz(func(t *testing.T, nalu []byte) {
+		sps, err := ParseSPS(nalu)
+		if err != nil {
+			return
+		}
+		if sps.Width <= 0 || sps.Height <= 0 || sps.Width > maxFrameSize || sps.Height > maxFrameSize {
+			t.Fatalf("недопустимый размер %dx%d", sps.Width, sps.Height)
+		}
+		if sps.FrameRate < 0 {
+			t.Fatalf("отрицательная частота кадров %v", sps.FrameRate)
+		}
+		_ = sps.String()
+	})
+}
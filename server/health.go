@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// HealthChecker проверяет, жив ли сервер и может ли он принимать и записывать потоки
+type HealthChecker struct {
+	OutputDir    string
+	MinFreeBytes atomic.Uint64 // минимальный запас свободного места (0 — без проверки)
+	Ingest       *IngestHandler
+	StartedAt    time.Time
+}
+
+// healthCheck — результат одной проверки готовности
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthStatus — ответ /healthz и /readyz
+type healthStatus struct {
+	Status string        `json:"status"`
+	Uptime string        `json:"uptime,omitempty"`
+	Checks []healthCheck `json:"checks,omitempty"`
+}
+
+// Ready выполняет проверки готовности и сообщает, прошли ли все
+func (c *HealthChecker) Ready() (bool, []healthCheck) {
+	checks := []healthCheck{
+		c.checkWritable(),
+		c.checkDiskFree(),
+		c.checkSessions(),
+	}
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+	return ready, checks
+}
+
+// checkWritable проверяет, что в директорию записей можно создать файл
+func (c *HealthChecker) checkWritable() healthCheck {
+	check := healthCheck{Name: "storage_writable"}
+	if err := os.MkdirAll(c.OutputDir, 0755); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	file, err := os.CreateTemp(c.OutputDir, ".readyz-*")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.OK = true
+	return check
+}
+
+// checkDiskFree проверяет запас свободного места в директории записей
+func (c *HealthChecker) checkDiskFree() healthCheck {
+	check := healthCheck{Name: "disk_free"}
+	minFree := c.MinFreeBytes.Load()
+	free, err := diskFree(c.OutputDir)
+	if err != nil {
+		// Без данных о диске готовность определяют остальные проверки
+		check.OK = minFree == 0
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.OK = free >= minFree
+	check.Detail = fmt.Sprintf("свободно %d байт, требуется не меньше %d", free, minFree)
+	return check
+}
+
+// checkSessions проверяет, что сервер принимает новые потоки
+func (c *HealthChecker) checkSessions() healthCheck {
+	check := healthCheck{Name: "sessions"}
+	status := c.Ingest.Status()
+	switch {
+	case status.Draining:
+		check.Detail = errDraining.Error()
+	case status.MaxSessions > 0 && status.Active >= status.MaxSessions:
+		check.Detail = fmt.Sprintf("%v: %d из %d", errTooManySessions, status.Active, status.MaxSessions)
+	default:
+		check.OK = true
+		check.Detail = fmt.Sprintf("активных сессий %d", status.Active)
+		if status.MaxSessions > 0 {
+			check.Detail += fmt.Sprintf(" из %d", status.MaxSessions)
+		}
+	}
+	return check
+}
+
+// healthzHandler отвечает на проверку живости: сервер обрабатывает запросы
+func healthzHandler(checker *HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, healthStatus{
+			Status: "ok",
+			Uptime: time.Since(checker.StartedAt).Round(time.Second).String(),
+		})
+	}
+}
+
+// readyzHandler отвечает на проверку готовности: 200, если сервер может записывать новые потоки, иначе 503
+func readyzHandler(checker *HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready, checks := checker.Ready()
+		status := healthStatus{Status: "ready", Checks: checks}
+		code := http.StatusOK
+		if !ready {
+			status.Status = "not_ready"
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, status)
+	}
+}
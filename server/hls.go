@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// hlsExtraSegments — сколько вышедших из плейлиста сегментов хранить для клиентов,
+// которые еще не успели их скачать
+const hlsExtraSegments = 2
+
+// HLSOptions задает параметры живого HLS-вещания
+type HLSOptions struct {
+	Enabled         bool          // формировать ли HLS для подключенных потоков
+	SegmentDuration time.Duration // желаемая длительность сегмента
+	Window          int           // количество сегментов в плейлисте
+}
+
+// hlsSegment — один сегмент живого плейлиста
+type hlsSegment struct {
+	sequence int
+	duration time.Duration
+	data     []byte
+}
+
+// hlsStream формирует скользящий HLS-плейлист из сегментов fMP4 в памяти.
+// Сегменты режутся по ключевым кадрам и не зависят от постоянной записи.
+type hlsStream struct {
+	mutex        sync.Mutex
+	options      HLSOptions
+	buf          bytes.Buffer
+	muxer        *fmp4Writer
+	init         []byte
+	segments     []*hlsSegment
+	nextSequence int
+	segmentStart time.Duration
+	ended        bool
+
+	// requestKeyframe просит камеру прислать ключевой кадр для нового сегмента; может быть nil
+	requestKeyframe func()
+}
+
+// newHLSStream создает новый hlsStream
+func newHLSStream(options HLSOptions) *hlsStream {
+	h := &hlsStream{options: options}
+	h.muxer = newFMP4Writer(&h.buf, nil, nil)
+	return h
+}
+
+// WriteAccessUnit добавляет кадр и при необходимости закрывает текущий сегмент
+func (h *hlsStream) WriteAccessUnit(au *h264.AccessUnit) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.ended {
+		return nil
+	}
+
+	initialized := h.muxer.initDone
+	due := initialized && au.PTS-h.segmentStart >= h.options.SegmentDuration
+	cut := due && au.Keyframe
+	if due && !au.Keyframe && h.requestKeyframe != nil {
+		h.requestKeyframe()
+	}
+
+	// Муксер сбрасывает предыдущую группу кадров в buf, получив следующий ключевой кадр
+	if err := h.muxer.WriteAccessUnit(au); err != nil {
+		return err
+	}
+
+	switch {
+	case !initialized && h.muxer.initDone:
+		h.init = bytes.Clone(h.buf.Bytes())
+		h.buf.Reset()
+		h.segmentStart = au.PTS
+	case cut:
+		h.finishSegment(au.PTS - h.segmentStart)
+		h.segmentStart = au.PTS
+	}
+	return nil
+}
+
+// Close завершает плейлист последним сегментом
+func (h *hlsStream) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.ended {
+		return nil
+	}
+	h.ended = true
+
+	last := h.lastPTS()
+	if err := h.muxer.Flush(); err != nil {
+		return err
+	}
+	if h.buf.Len() > 0 {
+		h.finishSegment(max(last-h.segmentStart, time.Millisecond))
+	}
+	return nil
+}
+
+// lastPTS возвращает время последнего кадра, еще не записанного во фрагмент
+func (h *hlsStream) lastPTS() time.Duration {
+	if n := len(h.muxer.samples); n > 0 {
+		return h.muxer.samples[n-1].pts + time.Second/30
+	}
+	return h.segmentStart
+}
+
+// finishSegment переносит накопленные фрагменты в новый сегмент и удаляет устаревшие
+func (h *hlsStream) finishSegment(duration time.Duration) {
+	h.segments = append(h.segments, &hlsSegment{
+		sequence: h.nextSequence,
+		duration: duration,
+		data:     bytes.Clone(h.buf.Bytes()),
+	})
+	h.nextSequence++
+	h.buf.Reset()
+
+	if extra := len(h.segments) - h.options.Window - hlsExtraSegments; extra > 0 {
+		h.segments = h.segments[extra:]
+	}
+}
+
+// playlist формирует текст плейлиста index.m3u8
+func (h *hlsStream) playlist() string {
+	listed := h.segments
+	if len(listed) > h.options.Window {
+		listed = listed[len(listed)-h.options.Window:]
+	}
+
+	target := h.options.SegmentDuration
+	for _, s := range listed {
+		target = max(target, s.duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	if len(listed) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", listed[0].sequence)
+	}
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	for _, s := range listed {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\nsegment_%d.m4s\n", s.duration.Seconds(), s.sequence)
+	}
+	if h.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+// ServeFile отдает плейлист, init-сегмент или медиасегмент по имени файла.
+// Данные ответа берутся под блокировкой, а отправляются без нее: медленный зритель
+// не должен задерживать запись кадров камеры.
+func (h *hlsStream) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+	contentType, data, ok := h.file(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentType)
+	if name == "index.m3u8" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Write(data)
+}
+
+// file возвращает тип и содержимое файла живого вещания. Init-сегмент и медиасегменты
+// после создания не меняются, поэтому их можно отдавать без копирования.
+func (h *hlsStream) file(name string) (string, []byte, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch {
+	case name == "index.m3u8":
+		return "application/vnd.apple.mpegurl", []byte(h.playlist()), true
+	case name == "init.mp4" && h.init != nil:
+		return "video/mp4", h.init, true
+	case strings.HasPrefix(name, "segment_") && strings.HasSuffix(name, ".m4s"):
+		sequence, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "segment_"), ".m4s"))
+		if err != nil {
+			break
+		}
+		for _, s := range h.segments {
+			if s.sequence == sequence {
+				return "video/iso.segment", s.data, true
+			}
+		}
+	}
+	return "", nil, false
+}
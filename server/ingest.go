@@ -0,0 +1,735 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"webcam-transfer/server/h264"
+)
+
+// closeStreamTakenOver — код закрытия для издателя, чей поток перехватил новый клиент
+const closeStreamTakenOver = 4001
+
+// videoConfigHeader — заголовок, в котором клиент сообщает параметры видео в JSON
+const videoConfigHeader = "X-Video-Config"
+
+// closeReasonInvalidStream — причина закрытия для клиента, присылающего не H.264 Annex-B
+const closeReasonInvalidStream = "поток не в формате H.264 Annex-B"
+
+// closeReasonInvalidFrame — причина закрытия для клиента, нарушившего согласованный формат кадров
+const closeReasonInvalidFrame = "некорректный заголовок кадра"
+
+// Политики при повторной публикации уже активного потока
+const (
+	streamConflictReject   = "reject"   // отклонять нового издателя
+	streamConflictTakeover = "takeover" // отключать прежнего издателя
+)
+
+var (
+	errStreamActive    = errors.New("поток уже публикуется")
+	errDraining        = errors.New("сервер выводится из работы")
+	errTooManySessions = errors.New("достигнут предел числа сессий")
+)
+
+// IngestHandler принимает видеопотоки камер по WebSocket на /ws и /ws/{stream}.
+// Auth, CertPolicy, StreamConflict и Limits после запуска меняются только через Reload.
+type IngestHandler struct {
+	Sessions       *SessionRegistry
+	Auth           *Authenticator
+	CertPolicy     *ClientCertPolicy
+	WriterOptions  WriterOptions
+	HLSOptions     HLSOptions
+	ViewerQueue    int
+	ResumeGrace    time.Duration // сколько сессия ждет возобновления после обрыва (0 — не ждет)
+	StreamConflict string        // streamConflictReject или streamConflictTakeover
+	Limits         IngestLimits
+
+	mutex      sync.Mutex
+	draining   bool
+	stopping   bool
+	active     int            // обработчики, которые еще не закрыли соединение
+	perIP      map[string]int // обработчики по IP-адресам клиентов
+	slots      int            // сессии, которые принимают поток или ждут возобновления
+	slotsPerIP map[string]int // сессии по IP-адресам клиентов, создавших их
+	idle       chan struct{}  // закрывается, когда не остается ни обработчиков, ни сессий
+}
+
+// SetDraining включает или выключает режим вывода из работы: новые потоки не принимаются,
+// активные продолжают запись до отключения
+func (h *IngestHandler) SetDraining(draining bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.draining = draining
+}
+
+// IngestStatus — состояние приема потоков
+type IngestStatus struct {
+	Draining    bool // включен режим вывода из работы
+	Active      int  // сессии, которые принимают поток или ждут возобновления
+	MaxSessions int  // предел одновременных потоков (0 — без ограничения)
+}
+
+// Status возвращает состояние приема потоков
+func (h *IngestHandler) Status() IngestStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return IngestStatus{
+		Draining:    h.draining,
+		Active:      h.slots,
+		MaxSessions: h.Limits.MaxSessions,
+	}
+}
+
+// Reload применяет настройки приема, которые можно менять без перезапуска.
+// Уже подключенные камеры не затрагиваются.
+func (h *IngestHandler) Reload(auth *Authenticator, certPolicy *ClientCertPolicy, streamConflict string, limits IngestLimits) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Auth = auth
+	h.CertPolicy = certPolicy
+	h.StreamConflict = streamConflict
+	h.Limits = limits
+}
+
+// Authenticator возвращает текущую проверку токенов или nil, если авторизация отключена
+func (h *IngestHandler) Authenticator() *Authenticator {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.Auth
+}
+
+// Shutdown перестает принимать потоки, закрывает активные сессии и ждет,
+// пока их записи будут дописаны, или отмены контекста
+func (h *IngestHandler) Shutdown(ctx context.Context) error {
+	h.mutex.Lock()
+	h.draining = true
+	h.stopping = true
+	h.mutex.Unlock()
+
+	// Сессии, которые зарегистрируются позже, закроет сам обработчик
+	for _, s := range h.Sessions.List() {
+		s.Disconnect(websocket.CloseGoingAway, "сервер останавливается")
+	}
+
+	h.mutex.Lock()
+	if h.active == 0 && h.slots == 0 {
+		h.mutex.Unlock()
+		return nil
+	}
+	if h.idle == nil {
+		h.idle = make(chan struct{})
+	}
+	idle := h.idle
+	h.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isStopping сообщает, что сервер останавливается
+func (h *IngestHandler) isStopping() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.stopping
+}
+
+// acquire учитывает новое соединение с адреса ip, если сервер не выводится из работы
+// и пределы числа подключений не достигнуты
+func (h *IngestHandler) acquire(ip string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if err := h.checkLimits(h.active, h.perIP[ip]); err != nil {
+		return err
+	}
+	if h.perIP == nil {
+		h.perIP = make(map[string]int)
+	}
+	h.active++
+	h.perIP[ip]++
+	return nil
+}
+
+// release отмечает, что соединение с адреса ip закрыто
+func (h *IngestHandler) release(ip string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.perIP[ip]--; h.perIP[ip] <= 0 {
+		delete(h.perIP, ip)
+	}
+	h.active--
+	h.notifyIdle()
+}
+
+// reserve занимает место новой сессии клиента с адреса ip. Место принадлежит сессии, а не соединению:
+// после обрыва сессия ждет возобновления и по-прежнему считается, а возобновление не занимает нового места.
+func (h *IngestHandler) reserve(ip string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if err := h.checkLimits(h.slots, h.slotsPerIP[ip]); err != nil {
+		return err
+	}
+	if h.slotsPerIP == nil {
+		h.slotsPerIP = make(map[string]int)
+	}
+	h.slots++
+	h.slotsPerIP[ip]++
+	return nil
+}
+
+// unreserve освобождает место сессии, созданной клиентом с адреса ip, когда ее запись закрыта
+func (h *IngestHandler) unreserve(ip string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.slotsPerIP[ip]--; h.slotsPerIP[ip] <= 0 {
+		delete(h.slotsPerIP, ip)
+	}
+	h.slots--
+	h.notifyIdle()
+}
+
+// checkLimits проверяет, можно ли добавить соединение или сессию, если всего их total, а с адреса клиента — fromIP.
+// Вызывается под h.mutex.
+func (h *IngestHandler) checkLimits(total, fromIP int) error {
+	switch {
+	case h.draining:
+		return errDraining
+	case h.Limits.MaxSessions > 0 && total >= h.Limits.MaxSessions:
+		return errTooManySessions
+	case h.Limits.MaxSessionsPerIP > 0 && fromIP >= h.Limits.MaxSessionsPerIP:
+		return errTooManySessionsPerIP
+	}
+	return nil
+}
+
+// notifyIdle сообщает Shutdown, что не осталось ни обработчиков, ни сессий. Вызывается под h.mutex.
+func (h *IngestHandler) notifyIdle() {
+	if h.active == 0 && h.slots == 0 && h.idle != nil {
+		close(h.idle)
+		h.idle = nil
+	}
+}
+
+// ServeHTTP проверяет клиента, создает сессию и принимает поток до отключения
+func (h *IngestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Идентификатор потока передается в пути или в параметре запроса
+	streamID := r.PathValue("stream")
+	if streamID == "" {
+		streamID = r.URL.Query().Get("stream")
+	}
+	if streamID != "" && sanitizeIdentity(streamID) != streamID {
+		http.Error(w, "некорректный идентификатор потока", http.StatusBadRequest)
+		return
+	}
+
+	h.mutex.Lock()
+	auth, certPolicy, streamConflict, limits := h.Auth, h.CertPolicy, h.StreamConflict, h.Limits
+	h.mutex.Unlock()
+
+	// Проверяем клиентский сертификат и токен до апгрейда соединения
+	var identity string
+	if certPolicy != nil {
+		var err error
+		identity, err = certPolicy.Identify(r)
+		if err != nil {
+			log.Printf("Отклонено подключение %s: %v", r.RemoteAddr, err)
+			http.Error(w, "клиентский сертификат не принят", http.StatusForbidden)
+			return
+		}
+	}
+
+	var subject string
+	if auth != nil {
+		var err error
+		subject, err = auth.Check(r)
+		if err != nil {
+			log.Printf("Отклонено подключение %s: %v", r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="webcam"`)
+			http.Error(w, "требуется авторизация", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Без сертификата идентификатором камеры служит субъект токена.
+	// Опознанная камера публикует только свои потоки: чужой поток она могла бы перехватить
+	// или смешать свои записи с записями другой камеры.
+	if identity == "" {
+		identity = sanitizeIdentity(subject)
+	}
+	if streamID != "" && !streamOwnedBy(streamID, identity) {
+		log.Printf("Отклонено подключение %s: камера %s не может публиковать поток %s", r.RemoteAddr, identity, streamID)
+		http.Error(w, "поток принадлежит другой камере", http.StatusForbidden)
+		return
+	}
+
+	ip := remoteIP(r.RemoteAddr)
+	if err := h.acquire(ip); err != nil {
+		log.Printf("Отклонено подключение %s: %v", r.RemoteAddr, err)
+		if errors.Is(err, errDraining) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "сервер не принимает новые потоки: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		// О превышении пределов клиент узнает из кода закрытия WebSocket
+		serverMetrics.LimitExceeded(sessionLimit(err))
+		rejectWithClose(w, r, websocket.CloseTryAgainLater, closeReasonSessions)
+		return
+	}
+	defer h.release(ip)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Ошибка при апгрейде до WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+	if limits.MaxMessageSize > 0 {
+		conn.SetReadLimit(limits.MaxMessageSize)
+	}
+
+	source := StreamSource{
+		ClientAddr: conn.RemoteAddr().String(),
+		Identity:   identity,
+		StreamID:   streamID,
+	}
+
+	// Первое сообщение определяет режим: клиент с протоколом управления присылает hello,
+	// старый клиент сразу начинает передавать видеоданные
+	control := &controlChannel{conn: conn}
+	hello, pending, err := readHello(conn)
+	var helloErr *helloError
+	switch {
+	case errors.As(err, &helloErr):
+		log.Printf("Отклонено подключение %s: %v", source.ClientAddr, err)
+		control.enabled = true
+		control.sendError(helloErr.code, helloErr.message)
+		closeWith(conn, websocket.CloseProtocolError, helloErr.message)
+		return
+	case errors.Is(err, websocket.ErrReadLimit):
+		log.Printf("Клиент %s превысил максимальный размер сообщения (%d байт)", source.ClientAddr, limits.MaxMessageSize)
+		serverMetrics.LimitExceeded(limitMessageSize)
+		return
+	case err != nil:
+		log.Printf("Клиент %s отключился, не начав передачу: %v", source.ClientAddr, err)
+		return
+	}
+	if hello != nil {
+		control.enabled = true
+		source.Config = hello.videoConfig()
+	} else {
+		source.Config = parseVideoConfig(r.Header.Get(videoConfigHeader))
+	}
+
+	disconnect := func(code int, reason string) {
+		control.sendError(closeErrorCode(code), reason)
+		closeWith(conn, code, reason)
+	}
+	resumable := hello != nil && hello.Resumable && h.ResumeGrace > 0
+
+	// Клиент после обрыва продолжает прежнюю сессию и ее запись
+	var session *Session
+	var generation int
+	if resumable && hello.Resume != nil {
+		session, generation = h.resume(hello.Resume, source, disconnect)
+	}
+	resumed := session != nil
+
+	if !resumed {
+		// Конфликт потоков проверяется после hello: камера, возобновляющая свою сессию,
+		// не должна получать отказ, пока сервер не заметил обрыв прежнего соединения
+		if streamID != "" && streamConflict != streamConflictTakeover {
+			if active, ok := h.Sessions.Stream(streamID); ok && active.Connected() {
+				log.Printf("Отклонено подключение %s: поток %s уже публикуется", source.ClientAddr, streamID)
+				control.sendError(errorStreamActive, errStreamActive.Error())
+				closeWith(conn, websocket.ClosePolicyViolation, errStreamActive.Error())
+				return
+			}
+		}
+
+		// Место сессии занимается до ее завершения, в том числе на время ожидания возобновления:
+		// иначе оборванные соединения позволили бы держать больше сессий, чем разрешают пределы
+		if err := h.reserve(ip); err != nil {
+			log.Printf("Отклонено подключение %s: %v", source.ClientAddr, err)
+			if errors.Is(err, errDraining) {
+				disconnect(websocket.CloseTryAgainLater, err.Error())
+				return
+			}
+			serverMetrics.LimitExceeded(sessionLimit(err))
+			disconnect(websocket.CloseTryAgainLater, closeReasonSessions)
+			return
+		}
+
+		// Создаем сессию и файл для сохранения потока
+		var err error
+		session, err = NewSession(source, h.WriterOptions, h.HLSOptions, h.ViewerQueue)
+		if err != nil {
+			h.unreserve(ip)
+			log.Printf("Не удалось создать запись: %v", err)
+			control.sendError(errorClosed, "не удалось создать запись")
+			return
+		}
+		session.SetFinish(func() {
+			h.Sessions.Remove(session.ID)
+			if err := session.Close(); err != nil {
+				log.Printf("Ошибка закрытия записи сессии %s: %v", session.ID, err)
+			}
+			h.unreserve(ip)
+			serverMetrics.SessionEnded()
+		})
+		generation, _ = session.Attach(disconnect)
+		serverMetrics.SessionStarted()
+
+		replaced, err := h.Sessions.Add(session, streamConflict == streamConflictTakeover)
+		if err != nil {
+			log.Printf("Отклонено подключение %s: поток %s уже публикуется", source.ClientAddr, streamID)
+			control.sendError(errorStreamActive, err.Error())
+			closeWith(conn, websocket.ClosePolicyViolation, err.Error())
+			session.Detach(generation, 0, err.Error())
+			return
+		}
+		if replaced != nil && replaced.Connected() {
+			log.Printf("Поток %s перехвачен: %s вместо %s", streamID, source.ClientAddr, replaced.ClientAddr)
+			replaced.Disconnect(closeStreamTakenOver, "поток перехвачен новым издателем")
+		} else if replaced != nil {
+			log.Printf("Поток %s публикуется заново, сессия %s больше не ждет возобновления", streamID, replaced.ID)
+			replaced.Disconnect(closeStreamTakenOver, "поток публикуется новой сессией")
+		}
+	}
+	if h.isStopping() {
+		session.Disconnect(websocket.CloseGoingAway, "сервер останавливается")
+	}
+
+	protocol := "без протокола управления"
+	if hello != nil {
+		protocol = fmt.Sprintf("протокол v%d", controlProtocolVersion)
+		if hello.Framed {
+			protocol += ", кадры с заголовком"
+		}
+		session.SetControl(control)
+		accept := newAcceptMessage(session.ID, hello.Framed, limits)
+		if resumable {
+			accept.ResumeGrace = int(h.ResumeGrace / time.Second)
+			accept.ResumeKey = session.EnableResume()
+		}
+		if resumed {
+			accept.Resumed = true
+			if last, ok := session.LastSequence(); ok {
+				accept.LastSequence = &last
+			}
+		}
+		if err := control.send(accept); err != nil {
+			log.Printf("Не удалось отправить accept клиенту %s: %v", source.ClientAddr, err)
+		}
+	}
+	if resumed {
+		log.Printf("Клиент переподключен: %s (сессия %s возобновлена%s, %s)", source.ClientAddr, session.ID, source.describe(), protocol)
+	} else {
+		log.Printf("Клиент подключен: %s (сессия %s%s, %s)", source.ClientAddr, session.ID, source.describe(), protocol)
+	}
+
+	stream := &ingestStream{
+		session: session,
+		source:  source,
+		limits:  limits,
+		bucket:  newTokenBucket(limits.MaxBitrate),
+		framed:  hello != nil && hello.Framed,
+		control: control,
+	}
+
+	// Обработка входящих сообщений. Сессию клиента, который умеет возобновлять ее,
+	// обрыв соединения не завершает: она ждет переподключения ResumeGrace.
+	var grace time.Duration
+	var reason string
+	ok := pending == nil || stream.handleVideo(pending)
+	for ok {
+		messageType, message, err := conn.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			// Библиотека уже отправила клиенту закрытие с кодом 1009
+			log.Printf("Клиент %s превысил максимальный размер сообщения (%d байт)", source.ClientAddr, limits.MaxMessageSize)
+			serverMetrics.LimitExceeded(limitMessageSize)
+			reason = fmt.Sprintf("превышен максимальный размер сообщения (код %d)", websocket.CloseMessageTooBig)
+			break
+		}
+		if err != nil {
+			log.Printf("Ошибка чтения: %v", err)
+			reason = describeReadError(err)
+			if resumable && isConnectionLost(err) {
+				grace = h.ResumeGrace
+			}
+			break
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			ok = stream.handleVideo(message)
+		case websocket.TextMessage:
+			stream.handleControl(message)
+		}
+	}
+	session.Detach(generation, grace, reason)
+
+	log.Printf("Клиент отключен: %s", source.ClientAddr)
+}
+
+// sessionLimit возвращает имя предела для метрик по ошибке acquire или reserve
+func sessionLimit(err error) string {
+	if errors.Is(err, errTooManySessionsPerIP) {
+		return limitSessionsPerIP
+	}
+	return limitSessions
+}
+
+// readHello ждет первое сообщение клиента. Для клиента с протоколом управления возвращается hello,
+// для старого клиента — первое сообщение с видеоданными.
+func readHello(conn *websocket.Conn) (*helloMessage, []byte, error) {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch messageType {
+		case websocket.BinaryMessage:
+			return nil, message, nil
+		case websocket.TextMessage:
+			hello, err := parseHello(message)
+			return hello, nil, err
+		}
+	}
+}
+
+// resume подключает клиента к сессии из hello.resume. Сессия должна быть создана клиентом,
+// умеющим возобновлять сессии, принадлежать той же камере и потоку, а клиент — предъявить
+// ее ключ возобновления. Сессия может ждать возобновления или еще числиться подключенной.
+// nil означает, что сессию продолжить нельзя и нужно начать новую.
+func (h *IngestHandler) resume(request *resumeRequest, source StreamSource, disconnect func(code int, reason string)) (*Session, int) {
+	session, ok := h.Sessions.Get(request.SessionID)
+	if !ok || session.Identity != source.Identity || session.StreamID != source.StreamID {
+		log.Printf("Клиент %s не может возобновить сессию %s: сессия не найдена, начата новая", source.ClientAddr, request.SessionID)
+		return nil, 0
+	}
+	generation, err := session.Resume(request.Key, disconnect)
+	if err != nil {
+		log.Printf("Клиент %s не может возобновить сессию %s: %v, начата новая", source.ClientAddr, request.SessionID, err)
+		return nil, 0
+	}
+
+	last, _ := session.LastSequence()
+	log.Printf("Сессия %s возобновлена: последний подтвержденный клиенту кадр %d, последний полученный %d",
+		session.ID, request.LastSequence, last)
+	return session, generation
+}
+
+// newAcceptMessage формирует ответ на hello с ограничениями сервера.
+// framed подтверждает клиенту, что кадры нужно передавать с заголовком.
+func newAcceptMessage(sessionID string, framed bool, limits IngestLimits) acceptMessage {
+	accept := acceptMessage{
+		Type:      messageAccept,
+		Version:   controlProtocolVersion,
+		SessionID: sessionID,
+		Framed:    framed,
+	}
+	if limits.MaxMessageSize > 0 || limits.MaxBitrate > 0 {
+		accept.Limits = &acceptLimits{
+			MaxMessageSize: limits.MaxMessageSize,
+			MaxBitrate:     limits.MaxBitrate,
+		}
+	}
+	return accept
+}
+
+// ingestStream обрабатывает сообщения одного подключенного издателя
+type ingestStream struct {
+	session *Session
+	source  StreamSource
+	limits  IngestLimits
+	bucket  *tokenBucket
+	framed  bool            // сообщения состоят из кадров с заголовком
+	skipped map[uint16]bool // дорожки, кадры которых пропускаются
+	control *controlChannel
+	ackedAt time.Time // время последнего подтверждения кадров
+}
+
+// handleVideo передает видеоданные сессии. false означает, что клиент отключен.
+func (s *ingestStream) handleVideo(message []byte) bool {
+	if s.bucket != nil && !s.bucket.allow(len(message)) {
+		log.Printf("Клиент %s превысил предел скорости потока (%d бит/с)", s.source.ClientAddr, s.limits.MaxBitrate)
+		serverMetrics.LimitExceeded(limitBitrate)
+		s.session.Disconnect(websocket.ClosePolicyViolation, closeReasonBitrate)
+		return false
+	}
+
+	serverMetrics.MessageReceived(s.session.Name(), len(message))
+	if !s.framed {
+		return s.handleWriteError(s.session.Write(message))
+	}
+
+	frames, err := parseFrames(message)
+	if err != nil {
+		log.Printf("Клиент %s прислал некорректный кадр: %v", s.source.ClientAddr, err)
+		s.session.Disconnect(websocket.CloseUnsupportedData, closeReasonInvalidFrame)
+		return false
+	}
+	video := frames[:0]
+	for _, frame := range frames {
+		if frame.Track != videoTrackID {
+			s.skipTrack(frame.Track)
+			continue
+		}
+		video = append(video, frame)
+	}
+	if !s.handleWriteError(s.session.WriteFrames(video)) {
+		return false
+	}
+	s.acknowledge()
+	return true
+}
+
+// acknowledge не чаще ackInterval сообщает клиенту номер последнего полученного кадра,
+// чтобы после обрыва клиент знал, с какого места сервер продолжит сессию
+func (s *ingestStream) acknowledge() {
+	now := time.Now()
+	if now.Sub(s.ackedAt) < ackInterval {
+		return
+	}
+	last, ok := s.session.LastSequence()
+	if !ok {
+		return
+	}
+	s.ackedAt = now
+	if err := s.control.send(ackMessage{Type: messageAck, Sequence: last}); err != nil {
+		log.Printf("Не удалось подтвердить кадры клиенту %s: %v", s.source.ClientAddr, err)
+	}
+}
+
+// skipTrack сообщает в журнал о первом кадре дорожки, которую сервер не записывает
+func (s *ingestStream) skipTrack(track uint16) {
+	if s.skipped[track] {
+		return
+	}
+	if s.skipped == nil {
+		s.skipped = make(map[uint16]bool)
+	}
+	s.skipped[track] = true
+	log.Printf("Клиент %s передает дорожку %d, ее кадры пропускаются", s.source.ClientAddr, track)
+}
+
+// handleWriteError обрабатывает результат записи видеоданных. false означает, что клиент отключен.
+func (s *ingestStream) handleWriteError(err error) bool {
+	if errors.Is(err, h264.ErrInvalidStream) {
+		log.Printf("Клиент %s прислал некорректные данные: %v", s.source.ClientAddr, err)
+		s.session.Disconnect(websocket.CloseUnsupportedData, closeReasonInvalidStream)
+		return false
+	}
+	if err != nil {
+		log.Printf("Ошибка записи данных: %v", err)
+		s.session.SetCloseReason(fmt.Sprintf("ошибка записи: %v", err))
+		return false
+	}
+	return true
+}
+
+// handleControl обрабатывает управляющее сообщение клиента.
+// Неизвестные типы пропускаются, чтобы новые клиенты могли работать со старым сервером.
+func (s *ingestStream) handleControl(data []byte) {
+	var message controlMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Printf("Некорректное управляющее сообщение от %s: %v", s.source.ClientAddr, err)
+		return
+	}
+	log.Printf("Пропущено управляющее сообщение %q от %s", message.Type, s.source.ClientAddr)
+}
+
+// parseVideoConfig разбирает параметры видео из заголовка старого клиента.
+// Некорректный заголовок не мешает записи и только попадает в журнал.
+func parseVideoConfig(header string) *clientVideoConfig {
+	if header == "" {
+		return nil
+	}
+	config := &clientVideoConfig{}
+	if err := json.Unmarshal([]byte(header), config); err != nil {
+		log.Printf("Некорректный заголовок %s: %v", videoConfigHeader, err)
+		return nil
+	}
+	return config
+}
+
+// describeReadError описывает причину отключения по ошибке чтения из WebSocket
+func describeReadError(err error) string {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure {
+		if closeErr.Text != "" {
+			return fmt.Sprintf("закрыто клиентом: %s (код %d)", closeErr.Text, closeErr.Code)
+		}
+		return fmt.Sprintf("закрыто клиентом (код %d)", closeErr.Code)
+	}
+	return fmt.Sprintf("ошибка соединения: %v", err)
+}
+
+// streamOwnedBy сообщает, может ли камера identity публиковать поток streamID:
+// поток должен называться как камера или начинаться с ее идентификатора и точки
+// (camera-1, camera-1.front). Без идентификатора камеры ограничений нет.
+func streamOwnedBy(streamID, identity string) bool {
+	return identity == "" || streamID == identity || strings.HasPrefix(streamID, identity+".")
+}
+
+// isConnectionLost сообщает, что соединение оборвалось, а не было закрыто клиентом
+func isConnectionLost(err error) bool {
+	var closeErr *websocket.CloseError
+	return !errors.As(err, &closeErr) || closeErr.Code == websocket.CloseAbnormalClosure
+}
+
+// rejectWithClose принимает WebSocket-соединение только для того, чтобы закрыть его с кодом и причиной
+func rejectWithClose(w http.ResponseWriter, r *http.Request, code int, reason string) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	closeWith(conn, code, reason)
+}
+
+// maxCloseReason — наибольшая длина причины в кадре закрытия: 125 байт без двух байт кода
+const maxCloseReason = 123
+
+// closeWith отправляет кадр закрытия с кодом и причиной и закрывает соединение.
+// Слишком длинная причина обрезается по границе символа.
+func closeWith(conn *websocket.Conn, code int, reason string) {
+	if len(reason) > maxCloseReason {
+		cut := maxCloseReason
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	deadline := time.Now().Add(time.Second)
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	conn.Close()
+}
+
+// describe возвращает поток и камеру клиента для сообщений журнала
+func (s StreamSource) describe() string {
+	var text string
+	if s.StreamID != "" {
+		text += fmt.Sprintf(", поток %s", s.StreamID)
+	}
+	if s.Identity != "" {
+		text += fmt.Sprintf(", камера %s", s.Identity)
+	}
+	return text
+}
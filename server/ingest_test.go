@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testIngest запускает прием потоков с возобновлением сессий и пределом потоков с одного адреса
+func testIngest(t *testing.T, perIP int) (*IngestHandler, string) {
+	t.Helper()
+	h := &IngestHandler{
+		Sessions:      NewSessionRegistry(),
+		WriterOptions: WriterOptions{OutputDir: t.TempDir(), Format: formatH264},
+		ViewerQueue:   1,
+		ResumeGrace:   time.Hour,
+		Limits:        IngestLimits{MaxSessionsPerIP: perIP},
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		server.Close()
+	})
+	return h, "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+// publish подключает камеру, отправляет hello и возвращает соединение.
+// accept равен nil, если сервер закрыл соединение, не приняв поток; тогда возвращается ошибка закрытия.
+func publish(t *testing.T, url string, resume *resumeRequest) (*websocket.Conn, *acceptMessage, error) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := helloMessage{Type: messageHello, Version: 1, Codec: "h264", Resumable: true, Resume: resume}
+	if err := conn.WriteJSON(hello); err != nil {
+		t.Fatal(err)
+	}
+
+	// До accept сервер может прислать только сообщение об ошибке
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		var accept acceptMessage
+		if err := conn.ReadJSON(&accept); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if accept.Type == messageAccept {
+			return conn, &accept, nil
+		}
+	}
+}
+
+// waitConnections ждет, пока сервер обработает закрытие всех соединений
+func waitConnections(t *testing.T, h *IngestHandler) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mutex.Lock()
+		active := h.active
+		h.mutex.Unlock()
+		if active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("сервер не заметил обрыв соединений: открыто %d", active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIngestDroppedSessionsKeepSlots(t *testing.T) {
+	const perIP = 2
+	h, url := testIngest(t, perIP)
+
+	// Соединения обрываются без кадра закрытия: сессии ждут возобновления
+	var accepts []*acceptMessage
+	for i := 0; i < perIP; i++ {
+		conn, accept, err := publish(t, url, nil)
+		if err != nil {
+			t.Fatalf("подключение %d: %v", i+1, err)
+		}
+		accepts = append(accepts, accept)
+		conn.UnderlyingConn().Close()
+	}
+	waitConnections(t, h)
+	if status := h.Status(); status.Active != perIP {
+		t.Errorf("Active = %d, ожидались %d ожидающие сессии", status.Active, perIP)
+	}
+
+	// Новая сессия с того же адреса превысила бы предел
+	_, _, err := publish(t, url, nil)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Fatalf("подключение %d: ошибка %v, ожидалось закрытие с кодом %d", perIP+1, err, websocket.CloseTryAgainLater)
+	}
+
+	// Возобновление не занимает нового места
+	conn, accept, err := publish(t, url, &resumeRequest{SessionID: accepts[0].SessionID, Key: accepts[0].ResumeKey})
+	if err != nil {
+		t.Fatalf("возобновление: %v", err)
+	}
+	if !accept.Resumed || accept.SessionID != accepts[0].SessionID {
+		t.Errorf("возобновление: accept %+v", accept)
+	}
+
+	// Место освобождается, только когда сессия завершена
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+	waitConnections(t, h)
+	if status := h.Status(); status.Active != perIP-1 {
+		t.Errorf("после завершения сессии Active = %d, ожидалось %d", status.Active, perIP-1)
+	}
+	conn, _, err = publish(t, url, nil)
+	if err != nil {
+		t.Fatalf("подключение после завершения сессии: %v", err)
+	}
+	conn.Close()
+}
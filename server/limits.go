@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ingestBurst — на сколько секунд потока при предельной скорости рассчитан запас токенов
+const ingestBurst = 3 * time.Second
+
+// Названия ограничений для журнала и метки limit метрики webcam_limit_exceeded_total
+const (
+	limitMessageSize    = "message_size"
+	limitBitrate        = "bitrate"
+	limitSessions       = "sessions"
+	limitSessionsPerIP  = "sessions_per_ip"
+	closeReasonBitrate  = "превышен предел скорости потока"
+	closeReasonSessions = "превышен предел числа подключений"
+)
+
+var errTooManySessionsPerIP = errors.New("достигнут предел числа сессий с одного адреса")
+
+// IngestLimits задает ограничения для камер
+type IngestLimits struct {
+	MaxSessions      int   // предел одновременных потоков (0 — без ограничения)
+	MaxSessionsPerIP int   // предел одновременных потоков с одного IP-адреса (0 — без ограничения)
+	MaxMessageSize   int64 // максимальный размер сообщения WebSocket в байтах (0 — без ограничения)
+	MaxBitrate       int64 // предельная скорость потока одного подключения в бит/с (0 — без ограничения)
+}
+
+// tokenBucket ограничивает скорость потока: каждое сообщение расходует токены по числу байт,
+// а токены пополняются с постоянной скоростью до размера запаса
+type tokenBucket struct {
+	rate   float64 // байт в секунду
+	burst  float64 // максимальный запас в байтах
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket создает ограничитель для скорости bitrate бит/с или nil, если скорость не ограничена
+func newTokenBucket(bitrate int64) *tokenBucket {
+	if bitrate <= 0 {
+		return nil
+	}
+	rate := float64(bitrate) / 8
+	burst := rate * ingestBurst.Seconds()
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// allow расходует n байт и сообщает, укладывается ли поток в ограничение
+func (b *tokenBucket) allow(n int) bool {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if float64(n) > b.tokens {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// remoteIP возвращает IP-адрес из адреса вида host:port
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	if newTokenBucket(0) != nil {
+		t.Error("без ограничения скорости ограничитель не нужен")
+	}
+
+	// 8000 бит/с — 1000 байт/с, запас на ingestBurst секунд
+	b := newTokenBucket(8000)
+	burst := int(1000 * ingestBurst.Seconds())
+	if !b.allow(burst) {
+		t.Fatalf("запас %d байт не пропущен сразу", burst)
+	}
+	if b.allow(10) {
+		t.Error("после исчерпания запаса сообщение пропущено без пополнения")
+	}
+
+	// Сообщение больше запаса не проходит никогда и не расходует токены
+	b = newTokenBucket(8000)
+	if b.allow(burst + 1) {
+		t.Error("пропущено сообщение больше запаса")
+	}
+	if !b.allow(burst) {
+		t.Error("отклоненное сообщение израсходовало токены")
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	b := newTokenBucket(8000)
+	burst := int(1000 * ingestBurst.Seconds())
+	b.allow(burst)
+
+	// За секунду пополняется 1000 байт
+	b.last = b.last.Add(-time.Second)
+	if !b.allow(900) {
+		t.Fatal("токены не пополнились за секунду")
+	}
+	if b.allow(200) {
+		t.Error("за секунду пополнилось больше 1000 байт")
+	}
+
+	// Пополнение не превышает запас, сколько бы ни длился перерыв
+	b.last = b.last.Add(-time.Hour)
+	if !b.allow(burst) {
+		t.Fatal("после перерыва запас не восстановился")
+	}
+	if b.allow(10) {
+		t.Error("после перерыва запас превысил ingestBurst")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1:5000":     "192.0.2.1",
+		"[2001:db8::1]:5000": "2001:db8::1",
+		"192.0.2.1":          "192.0.2.1",
+	}
+	for addr, want := range tests {
+		if got := remoteIP(addr); got != want {
+			t.Errorf("remoteIP(%q) = %q, ожидалось %q", addr, got, want)
+		}
+	}
+}
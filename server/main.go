@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,135 +21,225 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// VideoWriter управляет сохранением потока H.264 в файл
-type VideoWriter struct {
-	mutex      sync.Mutex
-	outputFile *os.File
-	filePath   string
-}
+func main() {
+	// Настройки из флагов, файла конфигурации и переменных окружения
+	config, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v", err)
+	}
+	if config.ConfigFile != "" {
+		log.Printf("Конфигурация загружена из %s", config.ConfigFile)
+	}
 
-// NewVideoWriter создает новый экземпляр VideoWriter
-func NewVideoWriter(outputDir string) (*VideoWriter, error) {
-	// Создаем директорию, если она не существует
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return nil, fmt.Errorf("не удалось создать директорию: %v", err)
+	auth, err := LoadAuthenticator(config.AuthKeyFile, config.AuthTokensFile, config.AuthAdminTokensFile)
+	if err != nil {
+		log.Fatalf("Ошибка настройки авторизации: %v", err)
+	}
+	if config.IssueToken > 0 {
+		scope := ""
+		if config.TokenAdmin {
+			scope = scopeAdmin
+		}
+		token, err := auth.IssueToken(config.TokenSubject, scope, config.IssueToken)
+		if err != nil {
+			log.Fatalf("Не удалось выпустить токен: %v", err)
+		}
+		fmt.Println(token)
+		return
 	}
 
-	// Генерируем имя файла на основе текущего времени
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	filePath := filepath.Join(outputDir, fmt.Sprintf("webcam_%s.h264", timestamp))
+	if auth != nil && auth.key == nil && auth.adminTokens == nil {
+		log.Printf("Не задан ни ключ подписи, ни токены администраторов: API управления недоступно")
+	}
 
-	// Создаем файл для записи
-	file, err := os.Create(filePath)
+	tlsOptions := config.tlsOptions()
+	certPolicy, err := LoadClientCertPolicy(tlsOptions)
 	if err != nil {
-		return nil, fmt.Errorf("не удалось создать файл: %v", err)
+		log.Fatalf("Ошибка настройки клиентских сертификатов: %v", err)
 	}
 
-	log.Printf("Запись в файл: %s", filePath)
+	sessions := NewSessionRegistry()
+
+	// Прием видеопотоков камер: /ws или именованный поток /ws/{stream}
+	ingest := &IngestHandler{
+		Sessions:       sessions,
+		Auth:           auth,
+		CertPolicy:     certPolicy,
+		WriterOptions:  config.writerOptions(),
+		HLSOptions:     config.hlsOptions(),
+		ViewerQueue:    config.ViewerQueue,
+		ResumeGrace:    config.ResumeGrace,
+		StreamConflict: config.StreamConflict,
+		Limits:         config.ingestLimits(),
+	}
+	http.Handle("/ws", ingest)
+	http.Handle("/ws/{stream}", ingest)
+
+	// Вывод сервера из работы перед развертыванием; менять режим при включенной авторизации
+	// можно только с токеном администратора
+	http.HandleFunc("GET /api/drain", drainHandler(ingest))
+	http.HandleFunc("POST /api/drain", requireAuth(ingest.Authenticator, drainHandler(ingest)))
+	http.HandleFunc("DELETE /api/drain", requireAuth(ingest.Authenticator, drainHandler(ingest)))
+
+	// Просмотр намеренно открыт без токена, как и список сессий ниже: токены защищают публикацию
+	// потоков и управление ими. Чтобы закрыть просмотр, сервер ставят за прокси с авторизацией.
+
+	// Живой HLS-плейлист и сегменты сессии
+	http.HandleFunc("GET /live/{session}/{file}", func(w http.ResponseWriter, r *http.Request) {
+		session, ok := sessions.Get(r.PathValue("session"))
+		if !ok || session.live == nil {
+			http.NotFound(w, r)
+			return
+		}
+		session.live.ServeFile(w, r, r.PathValue("file"))
+	})
 
-	return &VideoWriter{
-		outputFile: file,
-		filePath:   filePath,
-	}, nil
-}
+	// Трансляция кадров сессии зрителям через WebSocket: Annex-B или fMP4 для Media Source Extensions
+	http.HandleFunc("GET /watch/{session}", watchHandler(sessions, newAnnexBViewerEncoder))
+	http.HandleFunc("GET /watch/{session}/mp4", watchHandler(sessions, newFMP4ViewerEncoder))
 
-// Write записывает данные в файл
-func (vw *VideoWriter) Write(data []byte) error {
-	vw.mutex.Lock()
-	defer vw.mutex.Unlock()
+	// Список активных сессий для страницы просмотра
+	http.HandleFunc("GET /api/sessions", sessionsHandler(sessions))
+	http.HandleFunc("GET /api/sessions/{session}/quality", sessionQualityHandler(sessions))
 
-	_, err := vw.outputFile.Write(data)
-	return err
-}
+	// Команды камере: ключевой кадр, битрейт, пауза; при включенной авторизации — только с токеном администратора
+	http.HandleFunc("POST /api/sessions/{session}/commands", requireAuth(ingest.Authenticator, sessionCommandHandler(sessions)))
 
-// Close закрывает файл
-func (vw *VideoWriter) Close() error {
-	vw.mutex.Lock()
-	defer vw.mutex.Unlock()
+	// Записи в выходной директории; при включенной авторизации — только с токеном администратора
+	recordings := NewRecordingStore(config.OutputDir, sessions)
+	http.HandleFunc("GET /api/recordings", requireAuth(ingest.Authenticator, recordingsListHandler(recordings)))
+	http.HandleFunc("GET /api/recordings/{id...}", requireAuth(ingest.Authenticator, recordingDownloadHandler(recordings)))
+	http.HandleFunc("DELETE /api/recordings/{id...}", requireAuth(ingest.Authenticator, recordingDeleteHandler(recordings)))
 
-	if vw.outputFile != nil {
-		log.Printf("Закрытие файла: %s", vw.filePath)
-		err := vw.outputFile.Close()
-		vw.outputFile = nil
-		return err
+	// Проверки живости и готовности для оркестратора
+	health := &HealthChecker{
+		OutputDir: config.OutputDir,
+		Ingest:    ingest,
+		StartedAt: time.Now(),
 	}
-	return nil
-}
-
-func main() {
-	// Парсинг флагов командной строки
-	port := flag.Int("port", 8080, "порт для запуска сервера")
-	outputDir := flag.String("output", "recordings", "директория для сохранения записей")
-	flag.Parse()
-
-	// Обработчик WebSocket подключений
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("Ошибка при апгрейде до WebSocket: %v", err)
-			return
+	health.MinFreeBytes.Store(config.ReadyMinFreeBytes)
+	http.HandleFunc("GET /healthz", healthzHandler(health))
+	http.HandleFunc("GET /readyz", readyzHandler(health))
+
+	// Метрики в формате Prometheus
+	http.HandleFunc("GET /metrics", metricsHandler(serverMetrics, config.OutputDir))
+
+	// Останавливаемся по SIGINT и SIGTERM, дописав записи
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Удаление старых записей по политике хранения; политику можно включить и перезагрузкой
+	janitor := NewJanitor(recordings, config.retentionOptions())
+	go janitor.Run(ctx)
+
+	// По SIGHUP перечитываем настройки, которые можно менять на ходу
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		current := config
+		for range hangup {
+			current = reloadConfig(current, ingest, janitor, health)
 		}
-		defer conn.Close()
+	}()
+
+	// Страница состояния сервера и просмотра потоков
+	http.HandleFunc("/", indexHandler(config.OutputDir))
 
-		// Создаем файл для сохранения потока
-		videoWriter, err := NewVideoWriter(*outputDir)
+	// Запускаем HTTP-сервер
+	addr := fmt.Sprintf(":%d", config.Port)
+	server := &http.Server{Addr: addr}
+
+	serveErr := make(chan error, 1)
+	if tlsOptions.enabled() {
+		server.TLSConfig, err = buildTLSConfig(tlsOptions)
 		if err != nil {
-			log.Printf("Не удалось создать запись: %v", err)
-			return
-		}
-		defer videoWriter.Close()
-
-		clientAddr := conn.RemoteAddr().String()
-		log.Printf("Клиент подключен: %s", clientAddr)
-
-		// Обработка входящих сообщений
-		for {
-			messageType, message, err := conn.ReadMessage()
-			if err != nil {
-				log.Printf("Ошибка чтения: %v", err)
-				break
-			}
-
-			// Обрабатываем только бинарные сообщения (закодированные видеоданные)
-			if messageType == websocket.BinaryMessage {
-				err = videoWriter.Write(message)
-				if err != nil {
-					log.Printf("Ошибка записи данных: %v", err)
-					break
-				}
-			}
+			log.Fatalf("Ошибка настройки TLS: %v", err)
 		}
 
-		log.Printf("Клиент отключен: %s", clientAddr)
-	})
+		log.Printf("Запуск сервера на порту %d (TLS)...", config.Port)
+		log.Printf("Статус сервера доступен по адресу https://localhost%s", addr)
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
+	} else {
+		log.Printf("Запуск сервера на порту %d...", config.Port)
+		log.Printf("Статус сервера доступен по адресу http://localhost%s", addr)
+		go func() { serveErr <- server.ListenAndServe() }()
+	}
 
-	// Создаем простую страницу-статус
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(`
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>Сервер стриминга веб-камеры</title>
-			<style>
-				body { font-family: Arial, sans-serif; margin: 40px; }
-				.status { padding: 20px; background-color: #e0f7fa; border-radius: 5px; }
-			</style>
-		</head>
-		<body>
-			<h1>Сервер стриминга веб-камеры</h1>
-			<div class="status">
-				<p>✅ Сервер запущен и принимает соединения</p>
-				<p>Директория для записей: <code>` + *outputDir + `</code></p>
-			</div>
-		</body>
-		</html>
-		`))
-	})
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
 
-	// Запускаем HTTP-сервер
-	addr := fmt.Sprintf(":%d", *port)
-	log.Printf("Запуск сервера на порту %d...", *port)
-	log.Printf("Статус сервера доступен по адресу http://localhost%s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	// Перестаем принимать потоки, закрываем активные сессии и дописываем их записи
+	log.Printf("Остановка сервера: активных сессий %d", len(sessions.List()))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	if err := ingest.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Не все записи закрыты до истечения времени остановки: %v", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Ошибка остановки HTTP-сервера: %v", err)
+	}
+	log.Printf("Сервер остановлен")
+}
+
+// reloadConfig перечитывает конфигурацию и применяет настройки, которые можно менять без перезапуска.
+// Активные сессии не прерываются. Возвращает конфигурацию, которая действует после перезагрузки.
+func reloadConfig(current *Config, ingest *IngestHandler, janitor *Janitor, health *HealthChecker) *Config {
+	next, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Printf("Конфигурация не перезагружена: %v", err)
+		return current
+	}
+
+	// Сертификаты клиентов проверяются прежним CA: его смена требует перезапуска
+	tlsOptions := current.tlsOptions()
+	tlsOptions.RequireClientCert = next.TLSRequireCert
+	tlsOptions.CRLFile = next.TLSCRL
+	tlsOptions.DenyListFile = next.TLSDenyList
+	if tlsOptions.RequireClientCert && tlsOptions.ClientCAFile == "" {
+		log.Printf("Конфигурация не перезагружена: для обязательных клиентских сертификатов нужен tls-client-ca")
+		return current
+	}
+
+	auth, err := LoadAuthenticator(next.AuthKeyFile, next.AuthTokensFile, next.AuthAdminTokensFile)
+	if err != nil {
+		log.Printf("Конфигурация не перезагружена: %v", err)
+		return current
+	}
+	certPolicy, err := LoadClientCertPolicy(tlsOptions)
+	if err != nil {
+		log.Printf("Конфигурация не перезагружена: %v", err)
+		return current
+	}
+
+	// Токены, CRL и списки запретов перечитываются всегда: могли измениться сами файлы
+	ingest.Reload(auth, certPolicy, next.StreamConflict, next.ingestLimits())
+	janitor.SetOptions(next.retentionOptions())
+	health.MinFreeBytes.Store(next.ReadyMinFreeBytes)
+
+	// Остальные настройки продолжают действовать с прежними значениями
+	effective := *current
+	effective.values = make(map[string]string, len(current.values))
+	for name, value := range current.values {
+		effective.values[name] = value
+	}
+	effective.applyReloadable(next)
+	for _, name := range current.changes(next) {
+		if !reloadableSettings[name] {
+			log.Printf("Изменение настройки %s вступит в силу только после перезапуска сервера", name)
+			continue
+		}
+		log.Printf("Настройка %s изменена: %s", name, next.values[name])
+		effective.values[name] = next.values[name]
+	}
+
+	log.Printf("Конфигурация перезагружена")
+	return &effective
 }
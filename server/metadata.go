@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// metadataExt — расширение файла метаданных, который лежит рядом с записью
+const metadataExt = ".json"
+
+// recordingMetadata описывает сессию записи и ее файлы.
+// Файл метаданных лежит рядом с записью и заменяется атомарно при открытии сегментов и закрытии сессии.
+type recordingMetadata struct {
+	ClientAddr       string             `json:"client_addr"`
+	Identity         string             `json:"identity,omitempty"`
+	StreamID         string             `json:"stream_id,omitempty"`
+	StartedAt        time.Time          `json:"started_at"`
+	EndedAt          *time.Time         `json:"ended_at,omitempty"`
+	Bytes            int64              `json:"bytes"`
+	Messages         int64              `json:"messages"`
+	Video            *videoInfo         `json:"video,omitempty"`
+	ClientConfig     *clientVideoConfig `json:"client_config,omitempty"`
+	DisconnectReason string             `json:"disconnect_reason,omitempty"`
+	Quality          *qualityReport     `json:"quality,omitempty"`
+	Reconnects       int                `json:"reconnects,omitempty"` // сколько раз клиент возобновлял сессию
+	Files            []recordingFile    `json:"files"`
+}
+
+// videoInfo — параметры потока, определенные по SPS
+type videoInfo struct {
+	Codec     string  `json:"codec"`
+	Profile   string  `json:"profile"`
+	Level     string  `json:"level"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	FrameRate float64 `json:"frame_rate,omitempty"`
+}
+
+// newVideoInfo описывает параметры потока или возвращает nil, если SPS еще не получен
+func newVideoInfo(sps *h264.SPS) *videoInfo {
+	if sps == nil {
+		return nil
+	}
+	return &videoInfo{
+		Codec:     "h264",
+		Profile:   sps.Profile(),
+		Level:     sps.Level(),
+		Width:     sps.Width,
+		Height:    sps.Height,
+		FrameRate: sps.FrameRate,
+	}
+}
+
+// clientVideoConfig — параметры видео, о которых сообщил клиент в hello
+// или (старые клиенты) в заголовке videoConfigHeader
+type clientVideoConfig struct {
+	ClientID  string `json:"client_id,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	FrameRate int    `json:"frame_rate,omitempty"`
+	BitRate   int    `json:"bit_rate,omitempty"`
+	Codec     string `json:"codec,omitempty"`
+	DeviceID  string `json:"device_id,omitempty"`
+	Software  string `json:"software,omitempty"`
+}
+
+// recordingFile описывает один файл (сегмент) записи
+type recordingFile struct {
+	Name      string     `json:"name"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// hasFile проверяет, относится ли файл к этой сессии
+func (m *recordingMetadata) hasFile(name string) bool {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMetadata атомарно сохраняет метаданные: сначала во временный файл, затем переименованием
+func writeMetadata(path string, meta *recordingMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readMetadata читает метаданные записи
+func readMetadata(path string) (*recordingMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta := &recordingMetadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
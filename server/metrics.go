@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metricsMaxStreams ограничивает число различных значений метки stream;
+// потоки сверх лимита учитываются под меткой metricsOtherStream
+const metricsMaxStreams = 100
+
+// Значения метки stream для потоков без имени и сверх лимита
+const (
+	metricsAnonymousStream = "_anonymous"
+	metricsOtherStream     = "_other"
+)
+
+// writeLatencyBuckets — границы гистограммы времени записи кадра, в секундах
+var writeLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// serverMetrics — метрики сервера, которые отдаются на /metrics
+var serverMetrics = NewMetrics()
+
+// Metrics собирает счетчики сервера для Prometheus
+type Metrics struct {
+	mutex          sync.Mutex
+	activeSessions int
+	filesOpened    uint64
+	filesClosed    uint64
+	limitExceeded  map[string]uint64
+	streams        map[string]*streamMetrics
+}
+
+// streamMetrics — счетчики одного потока
+type streamMetrics struct {
+	bytes        uint64
+	messages     uint64
+	writeErrors  uint64
+	writeLatency histogram
+}
+
+// histogram — гистограмма с фиксированными границами writeLatencyBuckets
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics создает пустой набор метрик
+func NewMetrics() *Metrics {
+	return &Metrics{
+		limitExceeded: make(map[string]uint64),
+		streams:       make(map[string]*streamMetrics),
+	}
+}
+
+// SessionStarted учитывает подключение издателя
+func (m *Metrics) SessionStarted() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.activeSessions++
+}
+
+// SessionEnded учитывает отключение издателя
+func (m *Metrics) SessionEnded() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.activeSessions--
+}
+
+// MessageReceived учитывает сообщение потока stream размером size байт
+func (m *Metrics) MessageReceived(stream string, size int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.stream(stream)
+	s.messages++
+	s.bytes += uint64(size)
+}
+
+// WriteObserved учитывает запись кадра потока stream: ее длительность и результат
+func (m *Metrics) WriteObserved(stream string, duration time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.stream(stream)
+	if err != nil {
+		s.writeErrors++
+	}
+	s.writeLatency.observe(duration.Seconds())
+}
+
+// FileOpened учитывает открытие файла записи
+func (m *Metrics) FileOpened() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.filesOpened++
+}
+
+// FileClosed учитывает закрытие файла записи
+func (m *Metrics) FileClosed() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.filesClosed++
+}
+
+// LimitExceeded учитывает клиента, превысившего ограничение limit
+func (m *Metrics) LimitExceeded(limit string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.limitExceeded[limit]++
+}
+
+// stream возвращает счетчики потока, не допуская неограниченного роста числа меток
+func (m *Metrics) stream(name string) *streamMetrics {
+	if name == "" {
+		name = metricsAnonymousStream
+	}
+	s, ok := m.streams[name]
+	if ok {
+		return s
+	}
+	if len(m.streams) >= metricsMaxStreams {
+		name = metricsOtherStream
+		if s, ok := m.streams[name]; ok {
+			return s
+		}
+	}
+	s = &streamMetrics{}
+	m.streams[name] = s
+	return s
+}
+
+// observe добавляет значение в гистограмму
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(writeLatencyBuckets))
+	}
+	for i, bound := range writeLatencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// snapshot возвращает копию счетчиков, чтобы выводить их, не задерживая прием потоков
+func (m *Metrics) snapshot() *Metrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c := &Metrics{
+		activeSessions: m.activeSessions,
+		filesOpened:    m.filesOpened,
+		filesClosed:    m.filesClosed,
+		limitExceeded:  make(map[string]uint64, len(m.limitExceeded)),
+		streams:        make(map[string]*streamMetrics, len(m.streams)),
+	}
+	for limit, count := range m.limitExceeded {
+		c.limitExceeded[limit] = count
+	}
+	for name, s := range m.streams {
+		copied := *s
+		copied.writeLatency.counts = slices.Clone(s.writeLatency.counts)
+		c.streams[name] = &copied
+	}
+	return c
+}
+
+// Expose выводит метрики в текстовом формате Prometheus. Счетчики копируются под блокировкой,
+// а свободное место и вывод в w обходятся без нее: медленный сборщик метрик не задерживает камеры.
+func (m *Metrics) Expose(w io.Writer, outputDir string) error {
+	m = m.snapshot()
+
+	p := &metricsPrinter{w: w}
+	p.header("webcam_sessions_active", "gauge", "Количество подключенных издателей")
+	p.sample("webcam_sessions_active", "", float64(m.activeSessions))
+	p.header("webcam_files_opened_total", "counter", "Открыто файлов записи")
+	p.sample("webcam_files_opened_total", "", float64(m.filesOpened))
+	p.header("webcam_files_closed_total", "counter", "Закрыто файлов записи")
+	p.sample("webcam_files_closed_total", "", float64(m.filesClosed))
+
+	p.header("webcam_limit_exceeded_total", "counter", "Клиенты, превысившие ограничения")
+	for _, limit := range []string{limitMessageSize, limitBitrate, limitSessions, limitSessionsPerIP} {
+		p.sample("webcam_limit_exceeded_total", fmt.Sprintf("limit=%q", limit), float64(m.limitExceeded[limit]))
+	}
+
+	if free, err := diskFree(outputDir); err == nil {
+		p.header("webcam_disk_free_bytes", "gauge", "Свободное место в директории записей")
+		p.sample("webcam_disk_free_bytes", "", float64(free))
+	}
+
+	names := make([]string, 0, len(m.streams))
+	for name := range m.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p.header("webcam_received_bytes_total", "counter", "Получено байт видеопотока")
+	for _, name := range names {
+		p.sample("webcam_received_bytes_total", streamLabel(name), float64(m.streams[name].bytes))
+	}
+	p.header("webcam_received_messages_total", "counter", "Получено сообщений WebSocket с видеоданными")
+	for _, name := range names {
+		p.sample("webcam_received_messages_total", streamLabel(name), float64(m.streams[name].messages))
+	}
+	p.header("webcam_write_errors_total", "counter", "Ошибки записи кадров в файл")
+	for _, name := range names {
+		p.sample("webcam_write_errors_total", streamLabel(name), float64(m.streams[name].writeErrors))
+	}
+
+	p.header("webcam_write_duration_seconds", "histogram", "Длительность записи кадра VideoWriter")
+	for _, name := range names {
+		h := m.streams[name].writeLatency
+		for i, bound := range writeLatencyBuckets {
+			var count uint64
+			if h.counts != nil {
+				count = h.counts[i]
+			}
+			p.sample("webcam_write_duration_seconds_bucket", streamLabel(name)+fmt.Sprintf(`,le="%g"`, bound), float64(count))
+		}
+		p.sample("webcam_write_duration_seconds_bucket", streamLabel(name)+`,le="+Inf"`, float64(h.count))
+		p.sample("webcam_write_duration_seconds_sum", streamLabel(name), h.sum)
+		p.sample("webcam_write_duration_seconds_count", streamLabel(name), float64(h.count))
+	}
+	return p.err
+}
+
+// streamLabel формирует метку потока
+func streamLabel(name string) string {
+	return fmt.Sprintf("stream=%q", name)
+}
+
+// metricsPrinter пишет строки текстового формата Prometheus, запоминая первую ошибку
+type metricsPrinter struct {
+	w   io.Writer
+	err error
+}
+
+// header выводит описание и тип метрики
+func (p *metricsPrinter) header(name, kind, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample выводит значение метрики с метками labels
+func (p *metricsPrinter) sample(name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	p.printf("%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// printf выводит строку, если ранее не было ошибки
+func (p *metricsPrinter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+// metricsHandler отдает метрики в текстовом формате Prometheus
+func metricsHandler(metrics *Metrics, outputDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := metrics.Expose(w, outputDir); err != nil {
+			log.Printf("Ошибка отправки метрик: %v", err)
+		}
+	}
+}
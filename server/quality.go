@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// qualityTracker оценивает качество доставки кадров сессии: потери и нарушения порядка
+// по номерам кадров, джиттер по времени захвата и получения, фактическую частоту кадров.
+// Номера и время захвата есть только в кадрах с заголовком; для сырого потока
+// учитываются лишь сообщения и интервалы между ними.
+type qualityTracker struct {
+	sequenced  bool
+	frames     int64
+	lost       int64 // пропущенные номера, которые так и не пришли
+	outOfOrder int64 // кадры с номером меньше ожидаемого (опоздавшие и повторы)
+	nextSeq    uint32
+
+	firstPTS    time.Duration
+	lastPTS     time.Duration
+	firstAt     time.Time
+	lastAt      time.Time
+	lastTransit time.Duration // разница времени получения и захвата предыдущего кадра
+	jitter      float64       // сглаженный джиттер в секундах, как в RFC 3550
+	maxGap      time.Duration // наибольший интервал между получением кадров
+}
+
+// qualityReport — показатели качества доставки кадров
+type qualityReport struct {
+	Sequenced  bool    `json:"sequenced"` // кадры приходили с номерами и временем захвата
+	Frames     int64   `json:"frames"`
+	Lost       int64   `json:"lost"`
+	OutOfOrder int64   `json:"out_of_order"`
+	LossRatio  float64 `json:"loss_ratio"`
+	JitterMs   float64 `json:"jitter_ms"`
+	MaxGapMs   float64 `json:"max_gap_ms"`
+	FPS        float64 `json:"fps"`
+}
+
+// observe учитывает кадр с заголовком, полученный в момент arrival
+func (q *qualityTracker) observe(frame wireFrame, arrival time.Time) {
+	q.sequenced = true
+	first := q.frames == 0
+	q.countArrival(arrival)
+
+	switch delta := int32(frame.Sequence - q.nextSeq); {
+	case first || delta == 0:
+	case delta > 0:
+		q.lost += int64(delta)
+	default:
+		// Опоздавший кадр закрывает ранее учтенную потерю; дальше считать нечего
+		q.outOfOrder++
+		if q.lost > 0 {
+			q.lost--
+		}
+		return
+	}
+	q.nextSeq = frame.Sequence + 1
+
+	transit := arrival.Sub(q.firstAt) - frame.PTS
+	if first {
+		q.firstPTS = frame.PTS
+	} else {
+		d := transit - q.lastTransit
+		if d < 0 {
+			d = -d
+		}
+		q.jitter += (d.Seconds() - q.jitter) / 16
+	}
+	q.lastTransit = transit
+	q.lastPTS = frame.PTS
+}
+
+// observeMessage учитывает сообщение сырого потока, полученное в момент arrival
+func (q *qualityTracker) observeMessage(arrival time.Time) {
+	q.countArrival(arrival)
+}
+
+// countArrival учитывает время получения кадра
+func (q *qualityTracker) countArrival(arrival time.Time) {
+	if q.frames == 0 {
+		q.firstAt = arrival
+	} else {
+		q.maxGap = max(q.maxGap, arrival.Sub(q.lastAt))
+	}
+	q.lastAt = arrival
+	q.frames++
+}
+
+// report возвращает текущие показатели
+func (q *qualityTracker) report() *qualityReport {
+	r := &qualityReport{
+		Sequenced:  q.sequenced,
+		Frames:     q.frames,
+		Lost:       q.lost,
+		OutOfOrder: q.outOfOrder,
+		JitterMs:   roundTo(q.jitter*1000, 2),
+		MaxGapMs:   roundTo(float64(q.maxGap)/float64(time.Millisecond), 2),
+	}
+	if expected := q.frames + q.lost; expected > 0 {
+		r.LossRatio = roundTo(float64(q.lost)/float64(expected), 4)
+	}
+
+	// Частота кадров считается по времени захвата, а без него — по времени получения
+	span := q.lastAt.Sub(q.firstAt)
+	if q.sequenced {
+		span = q.lastPTS - q.firstPTS
+	}
+	if span > 0 && q.frames > 1 {
+		r.FPS = roundTo(float64(q.frames-q.outOfOrder-1)/span.Seconds(), 2)
+	}
+	return r
+}
+
+// String кратко описывает показатели для журнала
+func (r *qualityReport) String() string {
+	if !r.Sequenced {
+		return fmt.Sprintf("кадров %d, %.2f к/с, наибольшая пауза %.0f мс", r.Frames, r.FPS, r.MaxGapMs)
+	}
+	return fmt.Sprintf("кадров %d, потеряно %d (%.2f%%), не по порядку %d, джиттер %.2f мс, %.2f к/с, наибольшая пауза %.0f мс",
+		r.Frames, r.Lost, r.LossRatio*100, r.OutOfOrder, r.JitterMs, r.FPS, r.MaxGapMs)
+}
+
+// roundTo округляет значение до digits знаков после запятой
+func roundTo(v float64, digits int) float64 {
+	scale := math.Pow10(digits)
+	return math.Round(v*scale) / scale
+}
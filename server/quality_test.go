@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// observeFrames передает трекеру кадры с номерами seqs; кадр i захвачен в момент i*interval
+// и получен в момент arrival(i)
+func observeFrames(q *qualityTracker, seqs []uint32, interval time.Duration, arrival func(i int) time.Duration) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, seq := range seqs {
+		frame := wireFrame{Sequence: seq, PTS: time.Duration(i) * interval}
+		q.observe(frame, start.Add(arrival(i)))
+	}
+}
+
+// sequence возвращает номера n кадров подряд начиная с first
+func sequence(first uint32, n int) []uint32 {
+	seqs := make([]uint32, n)
+	for i := range seqs {
+		seqs[i] = first + uint32(i)
+	}
+	return seqs
+}
+
+func TestQualitySteadyStream(t *testing.T) {
+	var q qualityTracker
+	frame := 40 * time.Millisecond
+	observeFrames(&q, sequence(0, 26), frame, func(i int) time.Duration { return time.Duration(i) * frame })
+
+	want := qualityReport{Sequenced: true, Frames: 26, FPS: 25, MaxGapMs: 40}
+	if got := q.report(); *got != want {
+		t.Errorf("получено %+v, ожидалось %+v", *got, want)
+	}
+}
+
+func TestQualityLossAndReordering(t *testing.T) {
+	tests := []struct {
+		name       string
+		seqs       []uint32
+		lost       int64
+		outOfOrder int64
+		lossRatio  float64
+	}{
+		{"потеря", []uint32{0, 1, 4, 5}, 2, 0, 0.3333},
+		{"опоздавший кадр", []uint32{0, 1, 3, 4, 2, 5}, 0, 1, 0},
+		{"повтор", []uint32{0, 1, 2, 2, 3}, 0, 1, 0},
+		{"опоздал один из двух", []uint32{0, 3, 1, 4}, 1, 1, 0.2},
+		{"переполнение номера", []uint32{1<<32 - 2, 1<<32 - 1, 0, 1}, 0, 0, 0},
+		{"потеря при переполнении", []uint32{1<<32 - 1, 1}, 1, 0, 0.3333},
+		{"начало не с нуля", []uint32{100, 101, 102}, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		var q qualityTracker
+		observeFrames(&q, tt.seqs, 40*time.Millisecond, func(i int) time.Duration { return time.Duration(i) * 40 * time.Millisecond })
+		r := q.report()
+		if r.Frames != int64(len(tt.seqs)) || r.Lost != tt.lost || r.OutOfOrder != tt.outOfOrder || r.LossRatio != tt.lossRatio {
+			t.Errorf("%s: кадров %d, потеряно %d, не по порядку %d, доля потерь %v; ожидалось потеряно %d, не по порядку %d, доля %v",
+				tt.name, r.Frames, r.Lost, r.OutOfOrder, r.LossRatio, tt.lost, tt.outOfOrder, tt.lossRatio)
+		}
+	}
+}
+
+func TestQualityJitter(t *testing.T) {
+	var q qualityTracker
+	frame := 40 * time.Millisecond
+	// Каждый второй кадр приходит на 10 мс позже: время в пути меняется на 10 мс от кадра к кадру
+	observeFrames(&q, sequence(0, 200), frame, func(i int) time.Duration {
+		return time.Duration(i)*frame + time.Duration(i%2)*10*time.Millisecond
+	})
+
+	r := q.report()
+	if r.JitterMs < 9.9 || r.JitterMs > 10 {
+		t.Errorf("джиттер %v мс, ожидалось около 10", r.JitterMs)
+	}
+	if r.MaxGapMs != 50 {
+		t.Errorf("наибольшая пауза %v мс, ожидалось 50", r.MaxGapMs)
+	}
+	// Частота кадров считается по времени захвата и от задержек не зависит
+	if r.FPS != 25 {
+		t.Errorf("частота %v к/с, ожидалось 25", r.FPS)
+	}
+}
+
+func TestQualityBurstArrival(t *testing.T) {
+	var q qualityTracker
+	// Кадры захвачены с частотой 25 к/с, но пришли пачкой после задержки в сети
+	observeFrames(&q, sequence(0, 11), 40*time.Millisecond, func(i int) time.Duration {
+		return time.Second + time.Duration(i)*time.Millisecond
+	})
+
+	r := q.report()
+	if r.FPS != 25 {
+		t.Errorf("частота %v к/с, ожидалось 25 по времени захвата", r.FPS)
+	}
+	if r.JitterMs == 0 {
+		t.Error("неравномерная доставка не отразилась на джиттере")
+	}
+}
+
+func TestQualityRawMessages(t *testing.T) {
+	var q qualityTracker
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, ms := range []int{0, 100, 400, 500} {
+		q.observeMessage(start.Add(time.Duration(ms) * time.Millisecond))
+	}
+
+	want := qualityReport{Frames: 4, MaxGapMs: 300, FPS: 6}
+	r := q.report()
+	if *r != want {
+		t.Errorf("получено %+v, ожидалось %+v", *r, want)
+	}
+	if s := r.String(); strings.Contains(s, "потеряно") {
+		t.Errorf("для сырого потока в описании есть потери: %s", s)
+	}
+
+	var empty qualityTracker
+	if r := empty.report(); *r != (qualityReport{}) {
+		t.Errorf("без кадров: %+v", *r)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errRecordingNotFound = errors.New("запись не найдена")
+	errRecordingActive   = errors.New("запись еще продолжается")
+)
+
+// Recording описывает файл записи в выходной директории
+type Recording struct {
+	ID         string     `json:"id"`
+	Format     string     `json:"format"`
+	Size       int64      `json:"size"`
+	StartedAt  time.Time  `json:"started_at"`
+	EndedAt    time.Time  `json:"ended_at"`
+	ClientAddr string     `json:"client_addr,omitempty"`
+	Identity   string     `json:"identity,omitempty"`
+	StreamID   string     `json:"stream_id,omitempty"`
+	Video      *videoInfo `json:"video,omitempty"`
+	Active     bool       `json:"active"`
+
+	// Итоги сессии, к которой относится файл
+	DisconnectReason string         `json:"disconnect_reason,omitempty"`
+	Quality          *qualityReport `json:"quality,omitempty"`
+}
+
+// RecordingFilter отбирает записи при выводе списка
+type RecordingFilter struct {
+	From       time.Time // записи, закончившиеся не раньше
+	To         time.Time // записи, начавшиеся не позже
+	ClientAddr string    // подстрока адреса клиента
+	Identity   string    // идентификатор камеры
+	StreamID   string    // идентификатор потока
+	Format     string    // формат файла
+}
+
+// match проверяет, подходит ли запись под фильтр
+func (f RecordingFilter) match(rec *Recording) bool {
+	if !f.From.IsZero() && rec.EndedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && rec.StartedAt.After(f.To) {
+		return false
+	}
+	if f.ClientAddr != "" && !strings.Contains(rec.ClientAddr, f.ClientAddr) {
+		return false
+	}
+	if f.Identity != "" && rec.Identity != f.Identity {
+		return false
+	}
+	if f.StreamID != "" && rec.StreamID != f.StreamID {
+		return false
+	}
+	return f.Format == "" || rec.Format == f.Format
+}
+
+// RecordingStore дает доступ к записям в директории, куда пишет VideoWriter
+type RecordingStore struct {
+	dir      string
+	sessions *SessionRegistry
+}
+
+// NewRecordingStore создает хранилище записей
+func NewRecordingStore(dir string, sessions *SessionRegistry) *RecordingStore {
+	return &RecordingStore{
+		dir:      dir,
+		sessions: sessions,
+	}
+}
+
+// List возвращает записи, подходящие под фильтр, в порядке начала.
+// Записи камер с идентификатором лежат в поддиректориях, их ID включает имя поддиректории.
+// Сведения о записи берутся из файла метаданных ее сессии; для файлов без метаданных
+// время начала и окончания определяется по времени изменения файла.
+func (s *RecordingStore) List(filter RecordingFilter) ([]*Recording, error) {
+	type entry struct {
+		id   string
+		info fs.FileInfo
+	}
+
+	// Сведения о файлах из метаданных сессий
+	var entries []entry
+	files := make(map[string]recordingFile)
+	owners := make(map[string]*recordingMetadata)
+	err := filepath.WalkDir(s.dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == s.dir && os.IsNotExist(err) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, filePath)
+		if err != nil {
+			return nil
+		}
+		id := filepath.ToSlash(rel)
+
+		if filepath.Ext(id) == metadataExt {
+			meta, err := readMetadata(filePath)
+			if err != nil {
+				return nil
+			}
+			dir := path.Dir(id)
+			for _, f := range meta.Files {
+				files[path.Join(dir, f.Name)] = f
+				owners[path.Join(dir, f.Name)] = meta
+			}
+			return nil
+		}
+		if _, ok := recordingFormat(id); ok {
+			if info, err := d.Info(); err == nil {
+				entries = append(entries, entry{id: id, info: info})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	active := s.sessions.ActiveFiles()
+	recordings := []*Recording{}
+	for _, e := range entries {
+		format, _ := recordingFormat(e.id)
+		rec := &Recording{
+			ID:        e.id,
+			Format:    format,
+			Size:      e.info.Size(),
+			StartedAt: e.info.ModTime(),
+			EndedAt:   e.info.ModTime(),
+			Active:    active[filepath.Join(s.dir, filepath.FromSlash(e.id))],
+		}
+		if meta, ok := owners[e.id]; ok {
+			f := files[e.id]
+			rec.ClientAddr = meta.ClientAddr
+			rec.Identity = meta.Identity
+			rec.StreamID = meta.StreamID
+			rec.Video = meta.Video
+			rec.DisconnectReason = meta.DisconnectReason
+			rec.Quality = meta.Quality
+			rec.StartedAt = f.StartedAt
+			switch {
+			case f.EndedAt != nil:
+				rec.EndedAt = *f.EndedAt
+			case meta.EndedAt != nil:
+				rec.EndedAt = *meta.EndedAt
+			}
+		}
+
+		if filter.match(rec) {
+			recordings = append(recordings, rec)
+		}
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.Before(recordings[j].StartedAt)
+	})
+	return recordings, nil
+}
+
+// Open открывает законченную запись для чтения
+func (s *RecordingStore) Open(id string) (*os.File, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errRecordingNotFound
+	}
+	return file, err
+}
+
+// Delete удаляет законченную запись
+func (s *RecordingStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return errRecordingNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.removeOrphanedMetadata(id)
+	return nil
+}
+
+// removeOrphanedMetadata удаляет метаданные и индекс сессии, у которой не осталось файлов
+func (s *RecordingStore) removeOrphanedMetadata(id string) {
+	dir := filepath.Join(s.dir, filepath.FromSlash(path.Dir(id)))
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+metadataExt))
+	if err != nil {
+		return
+	}
+
+	name := path.Base(id)
+	for _, metaPath := range paths {
+		meta, err := readMetadata(metaPath)
+		if err != nil || meta.EndedAt == nil || !meta.hasFile(name) {
+			continue
+		}
+		for _, f := range meta.Files {
+			if _, err := os.Stat(filepath.Join(dir, f.Name)); err == nil {
+				return
+			}
+		}
+		os.Remove(metaPath)
+		os.Remove(strings.TrimSuffix(metaPath, metadataExt) + ".ffconcat")
+		if dir != filepath.Clean(s.dir) {
+			// Пустая поддиректория камеры больше не нужна; непустую os.Remove не тронет
+			os.Remove(dir)
+		}
+		return
+	}
+}
+
+// path проверяет идентификатор записи и возвращает путь к ее файлу.
+// Файлы, в которые еще идет запись, недоступны.
+func (s *RecordingStore) path(id string) (string, error) {
+	if _, ok := recordingFormat(id); !ok || strings.Contains(id, `\`) || !filepath.IsLocal(filepath.FromSlash(id)) {
+		return "", errRecordingNotFound
+	}
+	path := filepath.Join(s.dir, filepath.FromSlash(id))
+	if s.sessions.ActiveFiles()[path] {
+		return "", errRecordingActive
+	}
+	return path, nil
+}
+
+// recordingFormat определяет формат записи по имени файла
+func recordingFormat(name string) (string, bool) {
+	switch ext := strings.TrimPrefix(filepath.Ext(name), "."); ext {
+	case formatH264, formatMP4:
+		return ext, true
+	}
+	return "", false
+}
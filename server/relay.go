@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"webcam-transfer/server/h264"
+)
+
+// relayMaxGOP ограничивает кэш последней группы кадров, если ключевые кадры приходят слишком редко
+const relayMaxGOP = 600
+
+// viewerWriteTimeout — сколько ждать отправки кадра зрителю
+const viewerWriteTimeout = 10 * time.Second
+
+// frameViewer — подписчик живого потока со своей ограниченной очередью кадров
+type frameViewer struct {
+	queue        chan *h264.AccessUnit
+	waitKeyframe bool
+	skipped      int
+}
+
+// offer ставит кадр в очередь. Если очередь переполнена, зритель пропускает
+// кадры до следующего ключевого, не задерживая публикующего клиента.
+func (v *frameViewer) offer(au *h264.AccessUnit) {
+	if v.waitKeyframe {
+		if !au.Keyframe {
+			v.skipped++
+			return
+		}
+		v.waitKeyframe = false
+	}
+
+	select {
+	case v.queue <- au:
+	default:
+		v.waitKeyframe = true
+		v.skipped++
+	}
+}
+
+// frameRelay раздает кадры сессии зрителям.
+// Он хранит последние SPS/PPS и текущую группу кадров, чтобы подключившийся
+// позже зритель мог сразу начать декодирование.
+type frameRelay struct {
+	mutex     sync.Mutex
+	queueSize int
+	params    h264.ParameterSets
+	gop       []*h264.AccessUnit
+	viewers   map[*frameViewer]struct{}
+	closed    bool
+
+	// requestKeyframe просит камеру прислать ключевой кадр, если зрителю нечего показать; может быть nil
+	requestKeyframe func()
+}
+
+// newFrameRelay создает новый frameRelay
+func newFrameRelay(queueSize int) *frameRelay {
+	return &frameRelay{
+		queueSize: queueSize,
+		viewers:   make(map[*frameViewer]struct{}),
+	}
+}
+
+// Publish передает кадр всем зрителям и обновляет кэш
+func (r *frameRelay) Publish(au *h264.AccessUnit) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.params.Update(au)
+
+	switch {
+	case au.Keyframe:
+		r.gop = []*h264.AccessUnit{au}
+	case len(r.gop) > 0 && len(r.gop) < relayMaxGOP:
+		r.gop = append(r.gop, au)
+	default:
+		r.gop = nil
+	}
+
+	for v := range r.viewers {
+		v.offer(au)
+	}
+}
+
+// Subscribe добавляет зрителя. Очередь сразу заполняется параметрами и текущей группой кадров.
+// Если группы кадров в кэше нет, у камеры запрашивается ключевой кадр.
+func (r *frameRelay) Subscribe() *frameViewer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v := &frameViewer{
+		queue:        make(chan *h264.AccessUnit, r.queueSize+len(r.gop)),
+		waitKeyframe: len(r.gop) == 0,
+	}
+	if r.closed {
+		close(v.queue)
+		return v
+	}
+	if v.waitKeyframe && r.requestKeyframe != nil {
+		r.requestKeyframe()
+	}
+
+	for i, au := range r.gop {
+		if i == 0 {
+			au = r.params.Inject(au)
+		}
+		v.queue <- au
+	}
+	r.viewers[v] = struct{}{}
+	return v
+}
+
+// Unsubscribe отключает зрителя
+func (r *frameRelay) Unsubscribe(v *frameViewer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.viewers[v]; ok {
+		delete(r.viewers, v)
+		close(v.queue)
+	}
+}
+
+// Close завершает раздачу: очереди всех зрителей закрываются
+func (r *frameRelay) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	for v := range r.viewers {
+		close(v.queue)
+	}
+	r.viewers = make(map[*frameViewer]struct{})
+	r.gop = nil
+}
+
+// serveViewer отправляет зрителю кадры из его очереди, пока очередь не закроется или зритель не отключится.
+// encode преобразует кадр в сообщение; пустой результат не отправляется.
+func serveViewer(conn *websocket.Conn, viewer *frameViewer, encode func(*h264.AccessUnit) []byte) {
+	// Читаем входящие сообщения, чтобы обработать управляющие кадры и заметить отключение
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case au, ok := <-viewer.queue:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "трансляция завершена"),
+					time.Now().Add(time.Second))
+				return
+			}
+
+			data := encode(au)
+			if len(data) == 0 {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(viewerWriteTimeout))
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// watchHandler подключает зрителя к сессии. newEncoder создает для каждого зрителя
+// собственный преобразователь кадров в сообщения WebSocket.
+func watchHandler(sessions *SessionRegistry, newEncoder func() func(*h264.AccessUnit) []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := sessions.Get(r.PathValue("session"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Ошибка при апгрейде до WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		viewerAddr := conn.RemoteAddr().String()
+		log.Printf("Зритель подключен к сессии %s: %s", session.ID, viewerAddr)
+
+		viewer := session.relay.Subscribe()
+		serveViewer(conn, viewer, newEncoder())
+		session.relay.Unsubscribe(viewer)
+
+		log.Printf("Зритель отключен от сессии %s: %s (пропущено кадров: %d)", session.ID, viewerAddr, viewer.skipped)
+	}
+}
+
+// newAnnexBViewerEncoder отправляет каждый кадр как есть, в формате Annex-B
+func newAnnexBViewerEncoder() func(*h264.AccessUnit) []byte {
+	return func(au *h264.AccessUnit) []byte {
+		return au.AnnexB()
+	}
+}
+
+// newFMP4ViewerEncoder перепаковывает кадры в fMP4 на лету: первое сообщение содержит
+// init-сегмент, каждое следующее — фрагмент с одним кадром
+func newFMP4ViewerEncoder() func(*h264.AccessUnit) []byte {
+	var buf bytes.Buffer
+	muxer := newFMP4Writer(&buf, nil, nil)
+	muxer.fragmentPerFrame = true
+
+	return func(au *h264.AccessUnit) []byte {
+		buf.Reset()
+		if err := muxer.WriteAccessUnit(au); err != nil {
+			log.Printf("Ошибка упаковки кадра в fMP4: %v", err)
+			return nil
+		}
+		return buf.Bytes()
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// RetentionOptions задает политику хранения записей
+type RetentionOptions struct {
+	MaxAge   time.Duration // максимальный возраст законченной записи (0 — без ограничения)
+	MaxBytes int64         // максимальный общий объем записей (0 — без ограничения)
+	Interval time.Duration // период проверки
+	DryRun   bool          // только сообщать, какие записи были бы удалены
+}
+
+// enabled сообщает, задано ли хотя бы одно ограничение
+func (o RetentionOptions) enabled() bool {
+	return o.MaxAge > 0 || o.MaxBytes > 0
+}
+
+// Janitor периодически удаляет старые записи согласно политике хранения.
+// Записи удаляются начиная с самых старых; файлы, в которые идет запись, не трогаются.
+// Возраст записи отсчитывается от времени окончания файла из метаданных сессии.
+type Janitor struct {
+	store   *RecordingStore
+	mutex   sync.Mutex
+	options RetentionOptions
+	changed chan struct{}
+}
+
+// NewJanitor создает новый Janitor
+func NewJanitor(store *RecordingStore, options RetentionOptions) *Janitor {
+	return &Janitor{
+		store:   store,
+		options: options,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// SetOptions меняет политику хранения; новая политика применяется сразу
+func (j *Janitor) SetOptions(options RetentionOptions) {
+	j.mutex.Lock()
+	j.options = options
+	j.mutex.Unlock()
+
+	select {
+	case j.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Options возвращает текущую политику хранения
+func (j *Janitor) Options() RetentionOptions {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.options
+}
+
+// Run выполняет проверки с заданным периодом до отмены контекста.
+// Пока ни одно ограничение не задано, проверки ничего не делают.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Options().Interval)
+	defer ticker.Stop()
+
+	for {
+		j.Sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-j.changed:
+			ticker.Reset(j.Options().Interval)
+		}
+	}
+}
+
+// Sweep выполняет одну проверку и удаляет записи, нарушающие политику
+func (j *Janitor) Sweep() {
+	options := j.Options()
+	if !options.enabled() {
+		return
+	}
+
+	recordings, err := j.store.List(RecordingFilter{})
+	if err != nil {
+		log.Printf("Хранение: не удалось получить список записей: %v", err)
+		return
+	}
+
+	var total int64
+	for _, rec := range recordings {
+		total += rec.Size
+	}
+
+	now := time.Now()
+	for _, rec := range recordings {
+		if rec.Active {
+			continue
+		}
+
+		expired := options.MaxAge > 0 && now.Sub(rec.EndedAt) > options.MaxAge
+		overQuota := options.MaxBytes > 0 && total > options.MaxBytes
+		if !expired && !overQuota {
+			continue
+		}
+
+		reason := "превышен объем"
+		if expired {
+			reason = "истек срок хранения"
+		}
+
+		if options.DryRun {
+			log.Printf("Хранение (пробный режим): была бы удалена запись %s (%d байт, %s)", rec.ID, rec.Size, reason)
+		} else {
+			if err := j.store.Delete(rec.ID); err != nil {
+				log.Printf("Хранение: не удалось удалить запись %s: %v", rec.ID, err)
+				continue
+			}
+			log.Printf("Хранение: удалена запись %s (%d байт, %s)", rec.ID, rec.Size, reason)
+		}
+		total -= rec.Size
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// makeRecording создает файл записи размером size, законченный в момент ended
+func makeRecording(t *testing.T, dir, name string, size int, ended time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, ended, ended); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// activeRecording отмечает файл как тот, в который сейчас идет запись
+func activeRecording(t *testing.T, registry *SessionRegistry, path string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	registry.sessions[path] = &Session{ID: path, writer: &VideoWriter{outputFile: file, filePath: path}}
+}
+
+// remainingRecordings возвращает отсортированные имена файлов в директории
+func remainingRecordings(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestJanitorMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	registry := NewSessionRegistry()
+	now := time.Now()
+	makeRecording(t, dir, "a.h264", 10, now.Add(-3*time.Hour))
+	makeRecording(t, dir, "b.mp4", 10, now.Add(-2*time.Hour))
+	makeRecording(t, dir, "c.h264", 10, now.Add(-10*time.Minute))
+	// Файл давно не менялся, но запись в него еще идет
+	activeRecording(t, registry, makeRecording(t, dir, "d.h264", 10, now.Add(-5*time.Hour)))
+	makeRecording(t, dir, "notes.txt", 10, now.Add(-5*time.Hour))
+
+	NewJanitor(NewRecordingStore(dir, registry), RetentionOptions{MaxAge: time.Hour}).Sweep()
+
+	want := []string{"c.h264", "d.h264", "notes.txt"}
+	if got := remainingRecordings(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("остались %v, ожидалось %v", got, want)
+	}
+}
+
+func TestJanitorMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	registry := NewSessionRegistry()
+	now := time.Now()
+	// Самая старая запись еще пишется: ее объем учитывается, но удаляются следующие по возрасту
+	activeRecording(t, registry, makeRecording(t, dir, "a.h264", 100, now.Add(-4*time.Hour)))
+	makeRecording(t, dir, "b.h264", 100, now.Add(-3*time.Hour))
+	makeRecording(t, dir, "c.h264", 100, now.Add(-2*time.Hour))
+	makeRecording(t, dir, "d.h264", 100, now.Add(-time.Hour))
+
+	NewJanitor(NewRecordingStore(dir, registry), RetentionOptions{MaxBytes: 250}).Sweep()
+
+	want := []string{"a.h264", "d.h264"}
+	if got := remainingRecordings(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("остались %v, ожидалось %v", got, want)
+	}
+
+	// В пределах объема ничего не удаляется
+	NewJanitor(NewRecordingStore(dir, registry), RetentionOptions{MaxBytes: 200}).Sweep()
+	if got := remainingRecordings(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("после повторной проверки остались %v, ожидалось %v", got, want)
+	}
+}
+
+func TestJanitorDryRun(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	makeRecording(t, dir, "a.h264", 100, now.Add(-3*time.Hour))
+	makeRecording(t, dir, "b.h264", 100, now.Add(-2*time.Hour))
+
+	NewJanitor(NewRecordingStore(dir, NewSessionRegistry()), RetentionOptions{MaxAge: time.Hour, MaxBytes: 1, DryRun: true}).Sweep()
+
+	want := []string{"a.h264", "b.h264"}
+	if got := remainingRecordings(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("в пробном режиме остались %v, ожидалось %v", got, want)
+	}
+}
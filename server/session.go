@@ -0,0 +1,519 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// errSessionEnded — сессия уже завершена и не может быть возобновлена
+var errSessionEnded = errors.New("сессия завершена")
+
+// errResumeDenied — клиент не предъявил ключ возобновления сессии
+var errResumeDenied = errors.New("неверный ключ возобновления сессии")
+
+// Session — публикация видеопотока клиентом.
+// Сессия разбирает поток на кадры и раздает их записи, живому вещанию и зрителям.
+// После обрыва соединения сессия может ждать возобновления клиентом (Detach и Attach),
+// продолжая ту же запись.
+type Session struct {
+	ID         string
+	ClientAddr string
+	Identity   string // идентификатор камеры из клиентского сертификата или токена
+	StreamID   string // идентификатор именованного потока
+	StartedAt  time.Time
+
+	mutex      sync.Mutex
+	parser     h264.Parser
+	video      *h264.SPS
+	lastPTS    time.Duration // время последнего кадра с заголовком
+	ptsOffset  time.Duration // сдвиг времени кадров клиента после возобновления
+	rebase     bool          // пересчитать ptsOffset по первому кадру после возобновления
+	resync     bool          // пропускать кадры до ключевого после возобновления
+	quality    qualityTracker
+	bytes      int64
+	messages   int64
+	reason     string // причина отключения
+	writer     *VideoWriter
+	live       *hlsStream
+	relay      *frameRelay
+	disconnect func(code int, reason string)
+	control    cameraControl
+
+	resumeKey  string      // секрет, которым клиент подтверждает право возобновить сессию
+	conn       int         // поколение подключения издателя; 0 — сессия ждет возобновления
+	generation int         // поколение последнего подключения; не повторяется после обрыва
+	ended      bool        // сервер завершил сессию, возобновить ее нельзя
+	detachedAt time.Time   // время обрыва соединения
+	orphan     *time.Timer // завершает сессию, если она не возобновлена
+	reconnects int
+	finish     func()
+	finishOnce sync.Once
+}
+
+// NewSession создает сессию и файл записи для нового подключения
+func NewSession(source StreamSource, writerOptions WriterOptions, hlsOptions HLSOptions, viewerQueue int) (*Session, error) {
+	writer, err := NewVideoWriter(writerOptions, source)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ID:         newSessionID(),
+		ClientAddr: source.ClientAddr,
+		Identity:   source.Identity,
+		StreamID:   source.StreamID,
+		StartedAt:  time.Now(),
+		writer:     writer,
+		relay:      newFrameRelay(viewerQueue),
+	}
+	if hlsOptions.Enabled {
+		s.live = newHLSStream(hlsOptions)
+		s.live.requestKeyframe = func() { s.RequestKeyframe("пора начать HLS-сегмент") }
+	}
+
+	// Записи, живому вещанию и новым зрителям нужен ключевой кадр раньше, чем его пришлет кодировщик
+	writer.requestKeyframe = func() { s.RequestKeyframe("запись ждет ключевой кадр") }
+	s.relay.requestKeyframe = func() { s.RequestKeyframe("новый зритель") }
+	return s, nil
+}
+
+// Write разбирает очередное сообщение клиента без заголовков и передает готовые кадры потребителям.
+// Временем кадров считается время получения.
+// Ошибка, оборачивающая h264.ErrInvalidStream, означает, что клиент присылает не H.264 Annex-B.
+func (s *Session) Write(data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bytes += int64(len(data))
+	s.messages++
+	s.quality.observeMessage(time.Now())
+	return s.write(s.parser.Push(data, time.Since(s.StartedAt)))
+}
+
+// WriteFrames разбирает кадры одного сообщения с заголовками. Временем кадра считается
+// время захвата, которое передал клиент; время не может уменьшаться, иначе у кадров записи
+// получится отрицательная длительность.
+func (s *Session) WriteFrames(frames []wireFrame) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.messages++
+	now := time.Now()
+	for _, frame := range frames {
+		if s.rebase {
+			// После возобновления время клиента могло начаться заново: продолжаем шкалу записи
+			// с учетом длительности перерыва
+			s.ptsOffset = s.lastPTS + now.Sub(s.detachedAt) - frame.PTS
+			s.rebase = false
+		}
+		frame.PTS += s.ptsOffset
+
+		s.bytes += int64(len(frame.Payload))
+		s.quality.observe(frame, now)
+		s.lastPTS = max(s.lastPTS, frame.PTS)
+		if err := s.write(s.parser.PushUnit(frame.Payload, s.lastPTS)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write передает потребителям кадры, полученные от парсера, и обновляет параметры потока
+func (s *Session) write(units []*h264.AccessUnit, parseErr error) error {
+	if sps := s.parser.SPS(); sps != s.video {
+		s.video = sps
+		log.Printf("Параметры потока сессии %s: %s", s.ID, sps)
+		s.writer.UpdateMetadata(func(meta *recordingMetadata) {
+			meta.Video = newVideoInfo(sps)
+		})
+	}
+	if err := s.dispatch(units); err != nil {
+		return err
+	}
+	return parseErr
+}
+
+// Video возвращает параметры потока из последнего SPS или nil, если SPS еще не получен
+func (s *Session) Video() *h264.SPS {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.video
+}
+
+// Quality возвращает текущие показатели качества доставки кадров
+func (s *Session) Quality() *qualityReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.quality.report()
+}
+
+// Name возвращает имя потока: идентификатор потока или, если он не задан, камеры
+func (s *Session) Name() string {
+	if s.StreamID != "" {
+		return s.StreamID
+	}
+	return s.Identity
+}
+
+// SetFinish задает функцию, которая освобождает ресурсы сессии после ее завершения
+// (удаляет из реестра, закрывает запись). Функция вызывается один раз из Finish.
+func (s *Session) SetFinish(finish func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.finish = finish
+}
+
+// Finish завершает сессию. Повторные вызовы ничего не делают.
+func (s *Session) Finish() {
+	s.finishOnce.Do(func() {
+		s.mutex.Lock()
+		s.ended = true
+		if s.orphan != nil {
+			s.orphan.Stop()
+		}
+		finish := s.finish
+		s.mutex.Unlock()
+
+		if finish != nil {
+			finish()
+		}
+	})
+}
+
+// EnableResume разрешает возобновлять сессию и возвращает ключ возобновления.
+// Ключ создается при первом вызове и не совпадает с публичным идентификатором сессии.
+func (s *Session) EnableResume() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.resumeKey == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		s.resumeKey = hex.EncodeToString(b)
+	}
+	return s.resumeKey
+}
+
+// Resume подключает к сессии соединение клиента, предъявившего ключ возобновления key.
+// Сессию, для которой не вызывался EnableResume, возобновить нельзя.
+func (s *Session) Resume(key string, disconnect func(code int, reason string)) (int, error) {
+	s.mutex.Lock()
+	allowed := s.resumeKey != "" && subtle.ConstantTimeCompare([]byte(s.resumeKey), []byte(key)) == 1
+	s.mutex.Unlock()
+	if !allowed {
+		return 0, errResumeDenied
+	}
+	return s.Attach(disconnect)
+}
+
+// Attach подключает к сессии соединение издателя и возвращает его поколение.
+// disconnect закрывает это соединение. Если к сессии еще подключено прежнее соединение
+// (обрыв не успели заметить), оно закрывается. Завершенную сессию подключить нельзя.
+func (s *Session) Attach(disconnect func(code int, reason string)) (int, error) {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return 0, errSessionEnded
+	}
+
+	previous := s.disconnect
+	if s.conn != 0 {
+		s.detachedAt = time.Now()
+	}
+	resumed := !s.detachedAt.IsZero()
+	if resumed {
+		// Клиент продолжает поток после перерыва: время кадров нужно сдвинуть,
+		// а запись может продолжиться только с ключевого кадра
+		s.reconnects++
+		s.rebase = true
+		s.resync = true
+		s.reason = ""
+	}
+	if s.orphan != nil {
+		s.orphan.Stop()
+		s.orphan = nil
+	}
+	s.generation++
+	s.conn = s.generation
+	generation := s.conn
+	s.disconnect = disconnect
+	s.mutex.Unlock()
+
+	if resumed && previous != nil {
+		previous(closeStreamTakenOver, "сессия возобновлена новым подключением")
+	}
+	return generation, nil
+}
+
+// Detach отключает оборвавшееся соединение поколения generation. Сессия ждет возобновления
+// в течение grace, а затем завершается; при grace 0 или уже завершенной сервером сессии
+// она завершается сразу. reason — причина обрыва для метаданных записи.
+// Если к сессии успело подключиться новое соединение, ничего не меняется.
+func (s *Session) Detach(generation int, grace time.Duration, reason string) {
+	s.mutex.Lock()
+	if generation != s.conn {
+		s.mutex.Unlock()
+		return
+	}
+	if s.reason == "" {
+		s.reason = reason
+	}
+	s.conn = 0
+	s.disconnect = nil
+	if s.ended || grace <= 0 {
+		s.mutex.Unlock()
+		s.Finish()
+		return
+	}
+
+	// Оставшийся в парсере кадр дописываем сейчас: после перерыва поток продолжится с ключевого кадра
+	if err := s.dispatch(s.parser.Flush(s.lastPTS)); err != nil {
+		log.Printf("Ошибка записи последнего кадра: %v", err)
+	}
+	s.detachedAt = time.Now()
+	s.control.attach(s.ID, nil)
+
+	var orphan *time.Timer
+	orphan = time.AfterFunc(grace, func() {
+		s.mutex.Lock()
+		expired := s.orphan == orphan
+		s.mutex.Unlock()
+		if expired {
+			log.Printf("Сессия %s не возобновлена за %v, запись завершена", s.ID, grace)
+			s.Finish()
+		}
+	})
+	s.orphan = orphan
+	s.mutex.Unlock()
+
+	log.Printf("Сессия %s ждет возобновления %v: %s", s.ID, grace, reason)
+}
+
+// Connected сообщает, подключен ли сейчас издатель
+func (s *Session) Connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conn != 0
+}
+
+// Reconnects возвращает, сколько раз сессия была возобновлена
+func (s *Session) Reconnects() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.reconnects
+}
+
+// LastSequence возвращает номер последнего кадра, полученного по порядку
+func (s *Session) LastSequence() (uint32, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.quality.nextSeq - 1, s.quality.sequenced
+}
+
+// SetControl задает канал, через который камера принимает команды.
+// Для клиентов без протокола управления он не задается.
+func (s *Session) SetControl(channel *controlChannel) {
+	s.control.attach(s.ID, channel)
+}
+
+// Command отправляет камере команду. Для клиента без протокола управления
+// возвращается errControlUnsupported.
+func (s *Session) Command(command commandMessage) error {
+	return s.control.send(command)
+}
+
+// RequestKeyframe просит камеру как можно скорее прислать ключевой кадр.
+// Частые запросы объединяются; клиенту без протокола управления запрос не отправляется.
+func (s *Session) RequestKeyframe(reason string) {
+	s.control.requestKeyframe(reason)
+}
+
+// CameraState возвращает состояние камеры или nil, если она не принимает команды
+func (s *Session) CameraState() *cameraState {
+	return s.control.state()
+}
+
+// SetCloseReason запоминает причину отключения для метаданных записи.
+// Сохраняется первая причина: последующие обычно являются ее следствием.
+func (s *Session) SetCloseReason(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reason == "" {
+		s.reason = reason
+	}
+}
+
+// Disconnect завершает сессию по решению сервера: закрывает соединение издателя с кодом
+// и причиной закрытия WebSocket. Сессия, ждущая возобновления, сразу завершается.
+func (s *Session) Disconnect(code int, reason string) {
+	s.SetCloseReason(fmt.Sprintf("отключен сервером: %s (код %d)", reason, code))
+
+	s.mutex.Lock()
+	s.ended = true
+	disconnect := s.disconnect
+	s.mutex.Unlock()
+
+	if disconnect != nil {
+		disconnect(code, reason)
+	} else {
+		s.Finish()
+	}
+}
+
+// Close дописывает оставшиеся кадры и закрывает запись, сохраняя итоги сессии в ее метаданных
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.dispatch(s.parser.Flush(time.Since(s.StartedAt))); err != nil {
+		log.Printf("Ошибка записи последнего кадра: %v", err)
+	}
+	s.relay.Close()
+	if s.live != nil {
+		if err := s.live.Close(); err != nil {
+			log.Printf("Ошибка завершения HLS: %v", err)
+		}
+	}
+	quality := s.quality.report()
+	log.Printf("Качество потока сессии %s: %s", s.ID, quality)
+	s.writer.UpdateMetadata(func(meta *recordingMetadata) {
+		meta.Bytes = s.bytes
+		meta.Messages = s.messages
+		meta.DisconnectReason = s.reason
+		meta.Quality = quality
+		meta.Reconnects = s.reconnects
+	})
+	return s.writer.Close()
+}
+
+// dispatch передает кадры записи, живому вещанию и зрителям
+func (s *Session) dispatch(units []*h264.AccessUnit) error {
+	for _, au := range units {
+		if s.resync {
+			// Кадры, ссылающиеся на потерянные при обрыве, декодировать нельзя
+			if !au.Keyframe {
+				s.RequestKeyframe("сессия возобновлена")
+				continue
+			}
+			s.resync = false
+		}
+
+		started := time.Now()
+		err := s.writer.WriteAccessUnit(au)
+		serverMetrics.WriteObserved(s.Name(), time.Since(started), err)
+		if err != nil {
+			return err
+		}
+		s.relay.Publish(au)
+		if s.live != nil {
+			if err := s.live.WriteAccessUnit(au); err != nil {
+				// Ошибка живого вещания не должна прерывать запись
+				log.Printf("Ошибка HLS сессии %s: %v", s.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newSessionID генерирует случайный идентификатор сессии
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SessionRegistry хранит активные сессии
+type SessionRegistry struct {
+	mutex    sync.Mutex
+	sessions map[string]*Session
+	streams  map[string]*Session // сессии именованных потоков
+}
+
+// NewSessionRegistry создает пустой реестр сессий
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[string]*Session),
+		streams:  make(map[string]*Session),
+	}
+}
+
+// Add регистрирует сессию. Если ее поток уже публикуется, при takeover
+// возвращается вытесненная сессия, иначе — errStreamActive. Сессия, которая ждет
+// возобновления, вытесняется всегда.
+func (r *SessionRegistry) Add(s *Session, takeover bool) (*Session, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var replaced *Session
+	if s.StreamID != "" {
+		replaced = r.streams[s.StreamID]
+		if replaced != nil && !takeover && replaced.Connected() {
+			return nil, errStreamActive
+		}
+		r.streams[s.StreamID] = s
+	}
+	r.sessions[s.ID] = s
+	return replaced, nil
+}
+
+// Remove удаляет сессию из реестра
+func (r *SessionRegistry) Remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s, ok := r.sessions[id]; ok && s.StreamID != "" && r.streams[s.StreamID] == s {
+		delete(r.streams, s.StreamID)
+	}
+	delete(r.sessions, id)
+}
+
+// Stream возвращает сессию, публикующую именованный поток
+func (r *SessionRegistry) Stream(streamID string) (*Session, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, ok := r.streams[streamID]
+	return s, ok
+}
+
+// Get возвращает сессию по идентификатору
+func (r *SessionRegistry) Get(id string) (*Session, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+// ActiveFiles возвращает пути файлов, в которые сейчас идет запись
+func (r *SessionRegistry) ActiveFiles() map[string]bool {
+	active := make(map[string]bool)
+	for _, s := range r.List() {
+		if path := s.writer.CurrentFile(); path != "" {
+			active[filepath.Clean(path)] = true
+		}
+	}
+	return active
+}
+
+// List возвращает активные сессии в порядке подключения
+func (r *SessionRegistry) List() []*Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	list := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testSession создает сессию именованного потока с записью во временную директорию.
+// Возвращаемый канал закрывается, когда сессия завершена и запись закрыта.
+func testSession(t *testing.T) (*Session, chan struct{}) {
+	t.Helper()
+	s, err := NewSession(
+		StreamSource{ClientAddr: "127.0.0.1:5000", StreamID: "cam"},
+		WriterOptions{OutputDir: t.TempDir(), Format: formatH264},
+		HLSOptions{}, 1,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished := make(chan struct{})
+	s.SetFinish(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		close(finished)
+	})
+	t.Cleanup(s.Finish)
+	return s, finished
+}
+
+// isFinished сообщает, завершилась ли сессия в течение wait
+func isFinished(finished chan struct{}, wait time.Duration) bool {
+	select {
+	case <-finished:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+// noDisconnect — соединение, которое не должны закрывать
+func noDisconnect(t *testing.T) func(int, string) {
+	return func(code int, reason string) {
+		t.Errorf("соединение закрыто: %s (код %d)", reason, code)
+	}
+}
+
+func TestSessionGraceExpires(t *testing.T) {
+	s, finished := testSession(t)
+	key := s.EnableResume()
+	generation, err := s.Attach(noDisconnect(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Detach(generation, 20*time.Millisecond, "обрыв соединения")
+	if s.Connected() {
+		t.Error("после Detach издатель считается подключенным")
+	}
+	if !isFinished(finished, time.Second) {
+		t.Fatal("сессия не завершилась после истечения срока ожидания")
+	}
+
+	meta, err := readMetadata(s.writer.metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.EndedAt == nil || meta.DisconnectReason != "обрыв соединения" {
+		t.Errorf("метаданные: EndedAt = %v, DisconnectReason = %q", meta.EndedAt, meta.DisconnectReason)
+	}
+	if _, err := s.Resume(key, noDisconnect(t)); !errors.Is(err, errSessionEnded) {
+		t.Errorf("Resume после истечения срока: ошибка %v, ожидалась %v", err, errSessionEnded)
+	}
+}
+
+func TestSessionResumeWithinGrace(t *testing.T) {
+	s, finished := testSession(t)
+	key := s.EnableResume()
+	if again := s.EnableResume(); again != key {
+		t.Errorf("повторный EnableResume сменил ключ: %q, было %q", again, key)
+	}
+
+	first, err := s.Attach(noDisconnect(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, file := s.writer, s.writer.CurrentFile()
+
+	const grace = 50 * time.Millisecond
+	s.Detach(first, grace, "обрыв соединения")
+	second, err := s.Resume(key, noDisconnect(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Errorf("поколение соединения не изменилось: %d", second)
+	}
+	if !s.Connected() || s.Reconnects() != 1 {
+		t.Errorf("Connected = %v, Reconnects = %d", s.Connected(), s.Reconnects())
+	}
+	if s.writer != writer || s.writer.CurrentFile() != file {
+		t.Errorf("запись сменилась: %s, было %s", s.writer.CurrentFile(), file)
+	}
+
+	// Таймер ожидания остановлен
+	if isFinished(finished, 3*grace) {
+		t.Fatal("возобновленная сессия завершилась по таймеру ожидания")
+	}
+
+	s.Detach(second, 0, "клиент остановлен")
+	if !isFinished(finished, time.Second) {
+		t.Fatal("сессия не завершилась при Detach без ожидания")
+	}
+}
+
+func TestSessionStaleDetach(t *testing.T) {
+	tests := map[string]bool{
+		"после обрыва":            true,  // прежнее соединение отключено до возобновления
+		"полуоткрытое соединение": false, // обрыв прежнего соединения еще не замечен
+	}
+	for name, detached := range tests {
+		s, finished := testSession(t)
+		first, err := s.Attach(func(int, string) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detached {
+			s.Detach(first, time.Hour, "обрыв соединения")
+		}
+		second, err := s.Attach(noDisconnect(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second == first {
+			t.Errorf("%s: поколение %d выдано повторно", name, second)
+		}
+
+		// Обработчик прежнего соединения завершается позже и вызывает Detach со своим поколением
+		s.Detach(first, 0, "прежнее соединение закрыто")
+		s.Detach(first, 10*time.Millisecond, "прежнее соединение закрыто")
+		if !s.Connected() {
+			t.Errorf("%s: запоздалый Detach отключил возобновленную сессию", name)
+		}
+		if isFinished(finished, 50*time.Millisecond) {
+			t.Errorf("%s: запоздалый Detach завершил возобновленную сессию", name)
+		}
+	}
+}
+
+func TestSessionResumeReplacesHalfOpen(t *testing.T) {
+	s, _ := testSession(t)
+	key := s.EnableResume()
+
+	var closed []int
+	if _, err := s.Attach(func(code int, reason string) { closed = append(closed, code) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Клиент возобновляет сессию, пока сервер еще не заметил обрыв прежнего соединения
+	if _, err := s.Resume(key, noDisconnect(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 1 || closed[0] != closeStreamTakenOver {
+		t.Errorf("прежнее соединение закрыто с кодами %v, ожидался %d", closed, closeStreamTakenOver)
+	}
+	if s.Reconnects() != 1 {
+		t.Errorf("Reconnects = %d", s.Reconnects())
+	}
+}
+
+func TestSessionResumeDenied(t *testing.T) {
+	s, finished := testSession(t)
+	generation, err := s.Attach(noDisconnect(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Detach(generation, time.Hour, "обрыв соединения")
+
+	// Без EnableResume сессию нельзя возобновить даже пустым ключом
+	if _, err := s.Resume("", noDisconnect(t)); !errors.Is(err, errResumeDenied) {
+		t.Errorf("Resume без ключа: ошибка %v, ожидалась %v", err, errResumeDenied)
+	}
+
+	key := s.EnableResume()
+	for _, wrong := range []string{"", s.ID, key[:len(key)-1], key + "0"} {
+		if _, err := s.Resume(wrong, noDisconnect(t)); !errors.Is(err, errResumeDenied) {
+			t.Errorf("Resume с ключом %q: ошибка %v, ожидалась %v", wrong, err, errResumeDenied)
+		}
+	}
+	if s.Connected() || s.Reconnects() != 0 {
+		t.Errorf("отклоненный Resume подключил сессию: Connected = %v, Reconnects = %d", s.Connected(), s.Reconnects())
+	}
+	if isFinished(finished, 0) {
+		t.Error("отклоненный Resume завершил сессию")
+	}
+}
+
+func TestSessionResumeEnded(t *testing.T) {
+	tests := map[string]func(s *Session, generation int){
+		"Detach без ожидания": func(s *Session, generation int) {
+			s.Detach(generation, 0, "клиент остановлен")
+		},
+		"отключение во время ожидания": func(s *Session, generation int) {
+			s.Detach(generation, time.Hour, "обрыв соединения")
+			s.Disconnect(websocket.ClosePolicyViolation, "поток отклонен")
+		},
+		"Finish": func(s *Session, generation int) {
+			s.Finish()
+		},
+	}
+	for name, end := range tests {
+		s, finished := testSession(t)
+		key := s.EnableResume()
+		generation, err := s.Attach(func(int, string) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		end(s, generation)
+		if !isFinished(finished, time.Second) {
+			t.Errorf("%s: сессия не завершилась", name)
+			continue
+		}
+		if _, err := s.Resume(key, noDisconnect(t)); !errors.Is(err, errSessionEnded) {
+			t.Errorf("%s: Resume завершенной сессии: ошибка %v, ожидалась %v", name, err, errSessionEnded)
+		}
+	}
+}
+
+func TestSessionRegistryReplacesDetached(t *testing.T) {
+	registry := NewSessionRegistry()
+	first, _ := testSession(t)
+	generation, err := first.Attach(func(int, string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Add(first, false); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := testSession(t)
+	if _, err := registry.Add(second, false); !errors.Is(err, errStreamActive) {
+		t.Errorf("повторная публикация активного потока: ошибка %v, ожидалась %v", err, errStreamActive)
+	}
+
+	// Сессию, которая ждет возобновления, новый издатель вытесняет и без takeover
+	first.Detach(generation, time.Hour, "обрыв соединения")
+	replaced, err := registry.Add(second, false)
+	if err != nil || replaced != first {
+		t.Errorf("Add = %v, %v; ожидалась вытесненная сессия %s", replaced, err, first.ID)
+	}
+	if current, _ := registry.Stream("cam"); current != second {
+		t.Error("поток не перешел к новой сессии")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// selfSignedValidity — срок действия самоподписанного сертификата
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// TLSOptions задает параметры HTTPS/WSS
+type TLSOptions struct {
+	CertFile   string // сертификат сервера (PEM)
+	KeyFile    string // закрытый ключ сервера (PEM)
+	SelfSigned bool   // создать самоподписанный сертификат, если файлов еще нет
+
+	ClientCAFile      string // CA для проверки клиентских сертификатов камер (PEM)
+	RequireClientCert bool   // не принимать камеры без клиентского сертификата
+	CRLFile           string // список отзыва клиентских сертификатов (PEM или DER)
+	DenyListFile      string // запрещенные серийные номера или CN, по одному в строке
+}
+
+// enabled сообщает, включен ли TLS
+func (o TLSOptions) enabled() bool {
+	return o.CertFile != "" || o.SelfSigned
+}
+
+// buildTLSConfig загружает сертификат сервера и создает конфигурацию TLS.
+// При SelfSigned отсутствующий сертификат генерируется при первом запуске.
+func buildTLSConfig(options TLSOptions) (*tls.Config, error) {
+	if options.SelfSigned {
+		if options.CertFile == "" {
+			options.CertFile = "server.crt"
+		}
+		if options.KeyFile == "" {
+			options.KeyFile = "server.key"
+		}
+		if err := ensureSelfSignedCert(options.CertFile, options.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if options.CertFile == "" || options.KeyFile == "" {
+		return nil, fmt.Errorf("нужно указать и сертификат, и ключ")
+	}
+
+	cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить сертификат: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// Клиентский сертификат запрашивается у всех, но обязателен только для камер на /ws:
+	// браузерам зрителей он не нужен
+	if options.ClientCAFile != "" {
+		cas, err := loadCertificates(options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось загрузить CA клиентов: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		for _, ca := range cas {
+			config.ClientCAs.AddCert(ca)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// ensureSelfSignedCert создает самоподписанный сертификат, если нет ни файла сертификата, ни файла ключа.
+// Если есть только один из них, ничего не перезаписывается: возвращается ошибка с именем недостающего файла.
+func ensureSelfSignedCert(certFile, keyFile string) error {
+	certExists, err := fileExists(certFile)
+	if err != nil {
+		return err
+	}
+	keyExists, err := fileExists(keyFile)
+	if err != nil {
+		return err
+	}
+	switch {
+	case certExists && keyExists:
+		return nil
+	case certExists:
+		return fmt.Errorf("сертификат %s есть, но нет файла ключа %s", certFile, keyFile)
+	case keyExists:
+		return fmt.Errorf("ключ %s есть, но нет файла сертификата %s", keyFile, certFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"webcam-transfer"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("не удалось сохранить сертификат: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("не удалось сохранить ключ: %v", err)
+	}
+
+	log.Printf("Создан самоподписанный сертификат: %s", certFile)
+	return nil
+}
+
+// fileExists сообщает, существует ли файл
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("не удалось проверить файл %s: %v", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnsureSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls", "cert.pem"), filepath.Join(dir, "tls", "key.pem")
+
+	// Без обоих файлов сертификат создается, а при повторном запуске остается прежним
+	if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("созданная пара не загружается: %v", err)
+	}
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certFile); string(again) != string(cert) {
+		t.Error("существующий сертификат перезаписан")
+	}
+}
+
+func TestEnsureSelfSignedCertPartial(t *testing.T) {
+	tests := map[string]struct {
+		existing string // файл, который уже есть
+		missing  string // файл, который должен быть назван в ошибке
+	}{
+		"нет ключа":       {"cert.pem", "key.pem"},
+		"нет сертификата": {"key.pem", "cert.pem"},
+	}
+	for name, tt := range tests {
+		dir := t.TempDir()
+		existing := filepath.Join(dir, tt.existing)
+		if err := os.WriteFile(existing, []byte("не трогать"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := ensureSelfSignedCert(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+		if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, tt.missing)) {
+			t.Errorf("%s: ошибка %v, ожидалась с именем %s", name, err, tt.missing)
+		}
+		if data, _ := os.ReadFile(existing); string(data) != "не трогать" {
+			t.Errorf("%s: существующий файл %s перезаписан", name, tt.existing)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.missing)); !os.IsNotExist(err) {
+			t.Errorf("%s: недостающий файл %s создан", name, tt.missing)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(webFiles, "web/index.html"))
+
+// indexHandler отдает страницу состояния сервера со встроенным просмотром потоков
+func indexHandler(outputDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := indexTemplate.Execute(w, struct {
+			OutputDir string
+		}{
+			OutputDir: outputDir,
+		})
+		if err != nil {
+			log.Printf("Ошибка отображения страницы: %v", err)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// recordingTimeLayout — формат времени начала в именах файлов записи
+const recordingTimeLayout = "2006-01-02_15-04-05"
+
+// Форматы файлов записи
+const (
+	formatH264 = "h264" // сырой поток Annex-B
+	formatMP4  = "mp4"  // фрагментированный MP4
+)
+
+// WriterOptions задает параметры записи потока
+type WriterOptions struct {
+	OutputDir       string        // директория для записей
+	Format          string        // формат файлов: formatH264 или formatMP4
+	SegmentDuration time.Duration // максимальная длительность сегмента (0 — без ограничения)
+	SegmentSize     int64         // максимальный размер сегмента в байтах (0 — без ограничения)
+}
+
+// segmented сообщает, включена ли нарезка записи на сегменты
+func (o WriterOptions) segmented() bool {
+	return o.SegmentDuration > 0 || o.SegmentSize > 0
+}
+
+// accessUnitEncoder записывает кадры в файл конкретного формата
+type accessUnitEncoder interface {
+	WriteAccessUnit(au *h264.AccessUnit) error
+	Flush() error
+}
+
+// VideoWriter управляет сохранением потока H.264 в файл.
+// При заданных ограничениях запись нарезается на сегменты, каждый из которых
+// начинается с SPS, PPS и ключевого кадра и может быть воспроизведен отдельно.
+// Кадры до первого ключевого кадра не записываются.
+type VideoWriter struct {
+	mutex     sync.Mutex
+	options   WriterOptions
+	baseName  string
+	indexPath string
+	metaPath  string
+	meta      recordingMetadata
+	params    h264.ParameterSets
+	skipped   int // кадры, отброшенные до первого ключевого кадра сегмента
+
+	// requestKeyframe просит камеру прислать ключевой кадр, когда запись его ждет; может быть nil
+	requestKeyframe func()
+
+	outputFile   *os.File
+	filePath     string
+	encoder      accessUnitEncoder
+	segment      int
+	segmentBytes int64
+	segmentStart time.Duration
+	segmentEmpty bool
+	closed       bool // запись закрыта, метаданные завершены
+}
+
+// StreamSource описывает клиента, публикующего поток
+type StreamSource struct {
+	ClientAddr string             // адрес клиента
+	Identity   string             // идентификатор камеры из клиентского сертификата или токена
+	StreamID   string             // идентификатор потока из адреса подключения
+	Config     *clientVideoConfig // параметры видео, о которых сообщил клиент
+}
+
+// name возвращает имя, под которым сохраняются записи потока
+func (s StreamSource) name() string {
+	if s.StreamID != "" {
+		return s.StreamID
+	}
+	return s.Identity
+}
+
+// NewVideoWriter создает новый экземпляр VideoWriter для клиента source.
+// Записи именованного потока (или камеры с идентификатором) складываются в собственную
+// поддиректорию и получают это имя в начале имени файла.
+func NewVideoWriter(options WriterOptions, source StreamSource) (*VideoWriter, error) {
+	if options.Format != formatH264 && options.Format != formatMP4 {
+		return nil, fmt.Errorf("неизвестный формат записи: %s", options.Format)
+	}
+
+	prefix := "webcam"
+	if name := source.name(); name != "" {
+		prefix = name
+		options.OutputDir = filepath.Join(options.OutputDir, name)
+	}
+
+	// Создаем директорию, если она не существует
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("не удалось создать директорию: %v", err)
+	}
+
+	// Генерируем имя файла на основе текущего времени
+	now := time.Now()
+	vw := &VideoWriter{
+		options: options,
+		meta: recordingMetadata{
+			ClientAddr:   source.ClientAddr,
+			Identity:     source.Identity,
+			StreamID:     source.StreamID,
+			ClientConfig: source.Config,
+			StartedAt:    now,
+		},
+	}
+	if err := vw.reserveName(prefix + "_" + now.Format(recordingTimeLayout)); err != nil {
+		return nil, fmt.Errorf("не удалось создать метаданные записи: %v", err)
+	}
+
+	if options.segmented() {
+		vw.indexPath = filepath.Join(options.OutputDir, vw.baseName+".ffconcat")
+		if err := os.WriteFile(vw.indexPath, []byte("ffconcat version 1.0\n"), 0644); err != nil {
+			return nil, fmt.Errorf("не удалось создать индекс сегментов: %v", err)
+		}
+	}
+
+	if err := vw.openSegment(0); err != nil {
+		return nil, err
+	}
+	return vw, nil
+}
+
+// reserveName выбирает свободное базовое имя записи, атомарно создавая файл ее метаданных.
+// Если в ту же секунду уже началась другая запись, к имени добавляется номер.
+func (vw *VideoWriter) reserveName(baseName string) error {
+	for n := 1; ; n++ {
+		vw.baseName = baseName
+		if n > 1 {
+			vw.baseName = fmt.Sprintf("%s-%d", baseName, n)
+		}
+		vw.metaPath = filepath.Join(vw.options.OutputDir, vw.baseName+metadataExt)
+
+		file, err := os.OpenFile(vw.metaPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+}
+
+// WriteAccessUnit записывает кадр в текущий сегмент
+func (vw *VideoWriter) WriteAccessUnit(au *h264.AccessUnit) error {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+
+	if vw.outputFile == nil {
+		return os.ErrClosed
+	}
+	return vw.writeAccessUnit(au)
+}
+
+// Close закрывает текущий сегмент и завершает метаданные записи
+func (vw *VideoWriter) Close() error {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+
+	if vw.closed {
+		return nil
+	}
+	vw.closed = true
+
+	// Сегмента может не быть, если при смене сегмента не удалось открыть следующий файл:
+	// метаданные все равно нужно завершить, иначе запись будет выглядеть незаконченной
+	var err error
+	if vw.outputFile != nil {
+		err = vw.closeSegment()
+	}
+
+	now := time.Now()
+	vw.meta.EndedAt = &now
+	if metaErr := writeMetadata(vw.metaPath, &vw.meta); metaErr != nil {
+		log.Printf("Не удалось сохранить метаданные записи: %v", metaErr)
+	}
+	return err
+}
+
+// UpdateMetadata изменяет сведения о сессии в метаданных записи.
+// Изменения сохраняются при открытии следующего сегмента и при закрытии записи.
+func (vw *VideoWriter) UpdateMetadata(update func(meta *recordingMetadata)) {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+	update(&vw.meta)
+}
+
+// CurrentFile возвращает путь к файлу, в который сейчас идет запись
+func (vw *VideoWriter) CurrentFile() string {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+
+	if vw.outputFile == nil {
+		return ""
+	}
+	return vw.filePath
+}
+
+// writeAccessUnit записывает кадр, при необходимости начиная новый сегмент
+func (vw *VideoWriter) writeAccessUnit(au *h264.AccessUnit) error {
+	vw.params.Update(au)
+
+	// Сегмент начинается только с ключевого кадра: не ждем, пока кодировщик пришлет его сам
+	if !au.Keyframe && vw.requestKeyframe != nil && (vw.segmentEmpty || vw.segmentFull(au.PTS)) {
+		vw.requestKeyframe()
+	}
+
+	if au.Keyframe && vw.segmentFull(au.PTS) {
+		if err := vw.closeSegment(); err != nil {
+			return err
+		}
+		if err := vw.openSegment(au.PTS); err != nil {
+			return err
+		}
+	}
+
+	if vw.segmentEmpty {
+		// Файл должен начинаться с SPS, PPS и ключевого кадра, иначе плееры не смогут его открыть
+		if !au.Keyframe || !vw.params.Complete() {
+			vw.skipped++
+			return nil
+		}
+		if vw.skipped > 0 {
+			log.Printf("Пропущено кадров до первого ключевого кадра с SPS и PPS: %d (%s)", vw.skipped, vw.filePath)
+			vw.skipped = 0
+		}
+		au = vw.params.Inject(au)
+	}
+
+	vw.segmentEmpty = false
+	return vw.encoder.WriteAccessUnit(au)
+}
+
+// segmentFull проверяет, исчерпаны ли ограничения текущего сегмента
+func (vw *VideoWriter) segmentFull(pts time.Duration) bool {
+	if !vw.options.segmented() || vw.segmentEmpty {
+		return false
+	}
+	if vw.options.SegmentDuration > 0 && pts-vw.segmentStart >= vw.options.SegmentDuration {
+		return true
+	}
+	return vw.options.SegmentSize > 0 && vw.segmentBytes >= vw.options.SegmentSize
+}
+
+// openSegment создает файл очередного сегмента и добавляет его в индекс
+func (vw *VideoWriter) openSegment(pts time.Duration) error {
+	name := vw.baseName + "." + vw.options.Format
+	if vw.options.segmented() {
+		vw.segment++
+		name = fmt.Sprintf("%s_%03d.%s", vw.baseName, vw.segment, vw.options.Format)
+	}
+	filePath := filepath.Join(vw.options.OutputDir, name)
+
+	// Создаем файл для записи
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("не удалось создать файл: %v", err)
+	}
+	serverMetrics.FileOpened()
+
+	if vw.indexPath != "" {
+		if err := appendSegmentIndex(vw.indexPath, name); err != nil {
+			file.Close()
+			return fmt.Errorf("не удалось обновить индекс сегментов: %v", err)
+		}
+	}
+
+	vw.meta.Files = append(vw.meta.Files, recordingFile{Name: name, StartedAt: time.Now()})
+	if err := writeMetadata(vw.metaPath, &vw.meta); err != nil {
+		log.Printf("Не удалось сохранить метаданные записи: %v", err)
+	}
+
+	log.Printf("Запись в файл: %s", filePath)
+
+	vw.outputFile = file
+	vw.filePath = filePath
+	vw.segmentBytes = 0
+	vw.segmentStart = pts
+	vw.segmentEmpty = true
+
+	out := &countingWriter{w: file, n: &vw.segmentBytes}
+	if vw.options.Format == formatMP4 {
+		vw.encoder = newFMP4Writer(out, vw.params.SPS, vw.params.PPS)
+	} else {
+		vw.encoder = &annexBWriter{w: out}
+	}
+	return nil
+}
+
+// closeSegment дописывает и закрывает текущий сегмент
+func (vw *VideoWriter) closeSegment() error {
+	log.Printf("Закрытие файла: %s", vw.filePath)
+	if err := vw.encoder.Flush(); err != nil {
+		log.Printf("Ошибка записи последнего фрагмента: %v", err)
+	}
+	err := vw.outputFile.Close()
+	serverMetrics.FileClosed()
+	vw.outputFile = nil
+	vw.encoder = nil
+
+	now := time.Now()
+	vw.meta.Files[len(vw.meta.Files)-1].EndedAt = &now
+	return err
+}
+
+// appendSegmentIndex добавляет сегмент в индекс в формате ffconcat
+func appendSegmentIndex(indexPath, name string) error {
+	index, err := os.OpenFile(indexPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(index, "file '%s'\n", name)
+	if closeErr := index.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// annexBWriter записывает кадры сырым потоком Annex-B
+type annexBWriter struct {
+	w io.Writer
+}
+
+// WriteAccessUnit записывает NAL-единицы кадра со стартовыми кодами
+func (a *annexBWriter) WriteAccessUnit(au *h264.AccessUnit) error {
+	_, err := a.w.Write(au.AnnexB())
+	return err
+}
+
+// Flush ничего не делает: кадры пишутся сразу
+func (a *annexBWriter) Flush() error {
+	return nil
+}
+
+// countingWriter подсчитывает количество записанных байт
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+// Write записывает данные и увеличивает счетчик
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
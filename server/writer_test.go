@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"webcam-transfer/server/h264"
+)
+
+// testUnit создает кадр из одной NAL-единицы; ключевой кадр содержит SPS и PPS
+func testUnit(pts time.Duration, keyframe bool, payload ...byte) *h264.AccessUnit {
+	if keyframe {
+		idr := append([]byte{0x65, 0x88}, payload...)
+		return &h264.AccessUnit{NALUs: [][]byte{testHighSPS, testPPS, idr}, PTS: pts, Keyframe: true}
+	}
+	return &h264.AccessUnit{NALUs: [][]byte{append([]byte{0x41, 0x9a}, payload...)}, PTS: pts}
+}
+
+// annexBUnits записывает кадры сырым потоком, как annexBWriter
+func annexBUnits(units ...*h264.AccessUnit) []byte {
+	var out []byte
+	for _, au := range units {
+		out = append(out, au.AnnexB()...)
+	}
+	return out
+}
+
+// writeSegments записывает кадры с заданными ограничениями, проверяет индекс ffconcat
+// и возвращает содержимое сегментов
+func writeSegments(t *testing.T, options WriterOptions, units []*h264.AccessUnit) [][]byte {
+	t.Helper()
+	options.OutputDir = t.TempDir()
+	options.Format = formatH264
+	vw, err := NewVideoWriter(options, StreamSource{ClientAddr: "127.0.0.1:5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, au := range units {
+		if err := vw.WriteAccessUnit(au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(options.OutputDir, vw.baseName+"_*.h264"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var segments [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, data)
+	}
+	index, err := os.ReadFile(vw.indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Имена сегментов в индексе отличаются только номером
+	want := "ffconcat version 1.0\n"
+	for i := range paths {
+		want += "file '" + filepath.Base(paths[i]) + "'\n"
+	}
+	if string(index) != want {
+		t.Errorf("индекс:\n%s\nожидалось:\n%s", index, want)
+	}
+	return segments
+}
+
+func TestVideoWriterRotatesByDuration(t *testing.T) {
+	units := []*h264.AccessUnit{
+		testUnit(0, true, 1),
+		testUnit(500*time.Millisecond, false, 2),
+		testUnit(time.Second, false, 3), // срок вышел, но сегмент начинается только с ключевого кадра
+		testUnit(1200*time.Millisecond, true, 4),
+		testUnit(1500*time.Millisecond, false, 5),
+		testUnit(2000*time.Millisecond, true, 6), // 0.8 с от начала сегмента
+		testUnit(2200*time.Millisecond, true, 7), // ровно 1 с от начала сегмента
+	}
+	segments := writeSegments(t, WriterOptions{SegmentDuration: time.Second}, units)
+
+	want := [][]byte{
+		annexBUnits(units[0:3]...),
+		annexBUnits(units[3:6]...),
+		annexBUnits(units[6:]...),
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("сегментов %d, ожидалось %d", len(segments), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(segments[i], want[i]) {
+			t.Errorf("сегмент %d: % x,\nожидалось % x", i+1, segments[i], want[i])
+		}
+	}
+}
+
+func TestVideoWriterRotatesBySize(t *testing.T) {
+	units := []*h264.AccessUnit{
+		testUnit(0, true, 1),
+		testUnit(40*time.Millisecond, false, make([]byte, 100)...), // предел превышен
+		testUnit(80*time.Millisecond, true, 2),
+		testUnit(120*time.Millisecond, false, 3),
+	}
+	size := int64(len(annexBUnits(units[0])) + 1)
+	segments := writeSegments(t, WriterOptions{SegmentSize: size}, units)
+
+	if len(segments) != 2 {
+		t.Fatalf("сегментов %d, ожидалось 2", len(segments))
+	}
+	if !bytes.Equal(segments[0], annexBUnits(units[:2]...)) || !bytes.Equal(segments[1], annexBUnits(units[2:]...)) {
+		t.Errorf("кадры распределены по сегментам неверно: %d и %d байт", len(segments[0]), len(segments[1]))
+	}
+}
+
+func TestVideoWriterWithoutSegments(t *testing.T) {
+	dir := t.TempDir()
+	vw, err := NewVideoWriter(WriterOptions{OutputDir: dir, Format: formatH264}, StreamSource{ClientAddr: "127.0.0.1:5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := []*h264.AccessUnit{testUnit(0, true, 1), testUnit(time.Hour, true, 2)}
+	for _, au := range units {
+		if err := vw.WriteAccessUnit(au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Без ограничений запись идет в один файл без номера и без индекса, рядом лежат только метаданные
+	data, err := os.ReadFile(filepath.Join(dir, vw.baseName+".h264"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, annexBUnits(units...)) {
+		t.Errorf("запись % x", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("в директории %d файлов, ожидались запись и метаданные", len(entries))
+	}
+}
+
+func TestVideoWriterCloseWithoutSegment(t *testing.T) {
+	vw, err := NewVideoWriter(WriterOptions{OutputDir: t.TempDir(), Format: formatH264}, StreamSource{ClientAddr: "127.0.0.1:5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.WriteAccessUnit(testUnit(0, true, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Так запись выглядит, если при смене сегмента не удалось открыть следующий файл
+	if err := vw.closeSegment(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vw.Close(); err != nil {
+		t.Errorf("повторный Close: %v", err)
+	}
+
+	meta, err := readMetadata(vw.metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.EndedAt == nil {
+		t.Error("метаданные не завершены при закрытии без открытого сегмента")
+	}
+}